@@ -0,0 +1,28 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/maestroi/solana-faucet/backend/models"
+	"github.com/maestroi/solana-faucet/backend/utils"
+)
+
+// apiKeyFromRequest returns the API key sent as a bearer token or in the
+// X-API-Key header, if any
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// authenticateAPIKey looks up an active API key, returning nil if it is
+// unknown or revoked
+func (s *Server) authenticateAPIKey(key string) (*models.APIKey, error) {
+	return s.db.GetAPIKeyByHash(utils.HashAPIKey(key))
+}
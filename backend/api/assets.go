@@ -0,0 +1,78 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/maestroi/solana-faucet/backend/config"
+	"github.com/maestroi/solana-faucet/backend/utils"
+)
+
+// nativeAsset is the symbol used for SOL claims
+const nativeAsset = "SOL"
+
+// faucetAsset is something the faucet can hand out, either SOL or an SPL token
+type faucetAsset struct {
+	Symbol   string  `json:"symbol"`
+	Mint     string  `json:"mint,omitempty"`    // empty for SOL
+	Program  string  `json:"program,omitempty"` // token program for SPL assets
+	Amount   float64 `json:"amount"`
+	Cooldown int     `json:"cooldown"` // in seconds
+}
+
+// isNative reports whether the asset is SOL rather than a token
+func (a *faucetAsset) isNative() bool {
+	return a.Mint == ""
+}
+
+// buildAssets returns the assets offered by the faucet, SOL first
+func buildAssets(cfg *config.Config) []*faucetAsset {
+	assets := []*faucetAsset{{
+		Symbol:   nativeAsset,
+		Amount:   cfg.Solana.AmountPerRequest,
+		Cooldown: cfg.Security.ClaimCooldown,
+	}}
+	for _, t := range cfg.Tokens {
+		program := t.Program
+		if program == "" {
+			program = utils.TokenProgramSPL
+		}
+		assets = append(assets, &faucetAsset{
+			Symbol:   strings.ToUpper(t.Symbol),
+			Mint:     t.Mint,
+			Program:  program,
+			Amount:   t.Amount,
+			Cooldown: t.Cooldown,
+		})
+	}
+	return assets
+}
+
+// findAsset looks up an asset by symbol (case-insensitive) or mint address.
+// An empty name selects SOL.
+func (s *Server) findAsset(name string) *faucetAsset {
+	if name == "" {
+		name = nativeAsset
+	}
+	for _, asset := range s.state.Load().assets {
+		if strings.EqualFold(asset.Symbol, name) || (asset.Mint != "" && asset.Mint == name) {
+			return asset
+		}
+	}
+	return nil
+}
+
+// handleGetAssets lists the assets the faucet offers
+func (s *Server) handleGetAssets(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Success bool           `json:"success"`
+		Assets  []*faucetAsset `json:"assets"`
+	}{
+		Success: true,
+		Assets:  s.state.Load().assets,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
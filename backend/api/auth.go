@@ -0,0 +1,207 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/maestroi/solana-faucet/backend/auth"
+)
+
+// Cookies set by the sign-in flow
+const (
+	sessionCookieName = "faucet_session"
+	stateCookieName   = "faucet_oauth_state"
+)
+
+// oauthStateTTL is how long a user has to finish signing in with a provider
+const oauthStateTTL = 10 * time.Minute
+
+// handleAuthLogin starts signing in with an identity provider
+func (s *Server) handleAuthLogin(w http.ResponseWriter, r *http.Request) {
+	provider := s.authProviders[chi.URLParam(r, "provider")]
+	if provider == nil {
+		writeError(w, http.StatusNotFound, "Unknown sign-in provider")
+		return
+	}
+
+	// Bind the flow to this browser with a signed state cookie
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Printf("[Auth] Failed to generate state: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to start sign-in")
+		return
+	}
+	state := hex.EncodeToString(b)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    s.sessions.SignState(state),
+		Path:     "/api/auth",
+		MaxAge:   int(oauthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   s.cfg().Auth.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, provider.AuthCodeURL(state, s.authCallbackURL(provider)), http.StatusFound)
+}
+
+// handleAuthCallback finishes signing in and issues the session cookie. The
+// user is sent back to the frontend either way, with an auth_error query
+// parameter if signing in failed.
+func (s *Server) handleAuthCallback(w http.ResponseWriter, r *http.Request) {
+	provider := s.authProviders[chi.URLParam(r, "provider")]
+	if provider == nil {
+		writeError(w, http.StatusNotFound, "Unknown sign-in provider")
+		return
+	}
+
+	// The state cookie is single use
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Path:     "/api/auth",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.cfg().Auth.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		s.redirectAfterAuth(w, r, "Sign-in was cancelled")
+		return
+	}
+
+	// Check the state matches the one this browser started with
+	cookie, err := r.Cookie(stateCookieName)
+	if err != nil {
+		s.redirectAfterAuth(w, r, "Sign-in expired, please try again")
+		return
+	}
+	state, err := s.sessions.VerifyState(cookie.Value)
+	if err != nil || state == "" || state != r.URL.Query().Get("state") {
+		log.Printf("[Auth] Rejected callback with mismatched state from %s", clientIPFromRequest(r))
+		s.redirectAfterAuth(w, r, "Sign-in expired, please try again")
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), r.URL.Query().Get("code"), s.authCallbackURL(provider))
+	if err != nil {
+		log.Printf("[Auth] Failed to sign in with %s: %v", provider.Name(), err)
+		s.redirectAfterAuth(w, r, "Sign-in failed, please try again")
+		return
+	}
+
+	// New accounts are cheap to create, so they don't unlock anything
+	if minAge := s.cfg().Auth.MinAccountAge; minAge > 0 {
+		if identity.CreatedAt.IsZero() || time.Since(identity.CreatedAt) < time.Duration(minAge)*24*time.Hour {
+			log.Printf("[Auth] Rejected %s account %s, younger than %d days", provider.Name(), identity.Login, minAge)
+			s.redirectAfterAuth(w, r, fmt.Sprintf("Your %s account must be at least %d day%s old", provider.Name(), minAge, pluralize(minAge)))
+			return
+		}
+	}
+
+	token, err := s.sessions.Issue(identity)
+	if err != nil {
+		log.Printf("[Auth] Failed to issue session: %v", err)
+		s.redirectAfterAuth(w, r, "Sign-in failed, please try again")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(s.sessions.TTL().Seconds()),
+		HttpOnly: true,
+		Secure:   s.cfg().Auth.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	log.Printf("[Auth] Signed in %s as %s", identity.Key(), identity.Login)
+	s.redirectAfterAuth(w, r, "")
+}
+
+// handleAuthSession reports who is signed in and the allowance that unlocks
+func (s *Server) handleAuthSession(w http.ResponseWriter, r *http.Request) {
+	providers := make([]string, 0, len(s.authProviders))
+	for name := range s.authProviders {
+		providers = append(providers, name)
+	}
+
+	response := map[string]interface{}{
+		"success":       true,
+		"enabled":       s.sessions != nil,
+		"providers":     providers,
+		"authenticated": false,
+	}
+
+	if identity := s.sessionIdentity(r); identity != nil {
+		response["authenticated"] = true
+		response["provider"] = identity.Provider
+		response["login"] = identity.Login
+		response["amount"] = s.cfg().Auth.Amount
+		response["dailyClaims"] = s.cfg().Auth.DailyClaims
+		response["cooldown"] = s.cfg().Auth.Cooldown
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleAuthLogout clears the session cookie. Sessions are stateless, so
+// this only signs out the calling browser.
+func (s *Server) handleAuthLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.cfg().Auth.CookieSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// sessionIdentity returns the signed-in user of a request, or nil
+func (s *Server) sessionIdentity(r *http.Request) *auth.Identity {
+	if s.sessions == nil {
+		return nil
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+	identity, err := s.sessions.Verify(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	// Sessions outlive a provider being switched off
+	if s.authProviders[identity.Provider] == nil {
+		return nil
+	}
+	return identity
+}
+
+// authCallbackURL is the URL a provider sends users back to
+func (s *Server) authCallbackURL(provider auth.Provider) string {
+	return strings.TrimSuffix(s.cfg().Auth.PublicURL, "/") + "/api/auth/" + provider.Name() + "/callback"
+}
+
+// redirectAfterAuth sends the user back to the frontend, with an error
+// message if signing in failed
+func (s *Server) redirectAfterAuth(w http.ResponseWriter, r *http.Request, message string) {
+	target := s.cfg().Auth.RedirectURL
+	if message != "" {
+		sep := "?"
+		if strings.Contains(target, "?") {
+			sep = "&"
+		}
+		target += sep + "auth_error=" + url.QueryEscape(message)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
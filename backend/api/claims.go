@@ -0,0 +1,401 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/maestroi/solana-faucet/backend/auth"
+	"github.com/maestroi/solana-faucet/backend/db"
+	"github.com/maestroi/solana-faucet/backend/models"
+	"github.com/maestroi/solana-faucet/backend/utils"
+)
+
+// claimRequest is a validated request for a faucet payout, independent of
+// the transport (REST or JSON-RPC) it arrived on
+type claimRequest struct {
+	WalletAddress string
+	ClientIP      string
+	Asset         *faucetAsset
+	Amount        float64
+
+	// APIKey is set for claims authenticated with an API key, which replace
+	// the wallet and IP cooldowns with the key's quota
+	APIKey *models.APIKey
+
+	// Identity is set for claims by signed-in users, whose cooldown and
+	// daily cap are keyed on the identity instead of the wallet and IP
+	Identity *auth.Identity
+}
+
+// claimError is a payout failure that can be reported back to the client
+type claimError struct {
+	Status        int
+	Message       string
+	NextClaimTime time.Time
+}
+
+func (e *claimError) Error() string {
+	return e.Message
+}
+
+// enqueueClaim enforces the claim cooldowns and queues the payout for the
+// worker pool, returning the claim ID. It is shared by every endpoint that
+// pays out from the faucet.
+func (s *Server) enqueueClaim(req *claimRequest) (int64, *claimError) {
+	if req.APIKey != nil {
+		return s.enqueueKeyedClaim(req)
+	}
+	if req.Identity != nil {
+		return s.enqueueIdentityClaim(req)
+	}
+
+	// Claims are limited per subnet so a single machine can't drain the
+	// faucet by generating fresh wallets or rotating through its IPv6 range
+	cfg := s.cfg()
+	limits := db.SubnetLimits{
+		Cooldown:    cfg.Security.IPClaimCooldown,
+		DailyClaims: cfg.Security.IPDailyClaimLimit,
+	}
+	var subnet string
+	if n := clientSubnet(req.ClientIP, cfg.Security.IPv4SubnetPrefix, cfg.Security.IPv6SubnetPrefix); n != nil {
+		subnet = n.String()
+	} else if limits.Cooldown > 0 || limits.DailyClaims > 0 {
+		log.Printf("[Claim] Could not parse client IP %q, skipping IP limits", req.ClientIP)
+	}
+
+	// Check the wallet's cooldown and the subnet limits and reserve the claim
+	// in one step. The transaction row is the queue entry, so every attempt
+	// is auditable.
+	tx := &models.Transaction{
+		WalletAddress: req.WalletAddress,
+		IPAddress:     req.ClientIP,
+		Asset:         req.Asset.Symbol,
+		Amount:        req.Amount,
+		Status:        "queued",
+		Subnet:        subnet,
+		Timestamp:     time.Now(),
+	}
+	txID, history, err := s.db.ReserveClaim(tx, req.Asset.Cooldown, limits)
+	if errors.Is(err, db.ErrSubnetLimited) {
+		return 0, s.subnetLimitError(req.ClientIP, subnet, req.Asset.Symbol, limits)
+	}
+	if err != nil {
+		log.Printf("[Claim] Failed to queue claim: %v", err)
+		return 0, &claimError{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to record transaction",
+		}
+	}
+
+	if txID == 0 {
+		// The wallet claimed this asset recently
+		var nextClaimTime time.Time
+		if history != nil {
+			_, nextClaimTime = history.CanClaim(req.Asset.Cooldown)
+		}
+		return 0, &claimError{
+			Status:        http.StatusTooManyRequests,
+			Message:       fmt.Sprintf("Please wait until %s before requesting funds again", formatWaitTime(nextClaimTime)),
+			NextClaimTime: nextClaimTime,
+		}
+	}
+
+	s.queue.wake()
+	return txID, nil
+}
+
+// enqueueKeyedClaim queues a claim made with an API key, within the key's
+// daily quota
+func (s *Server) enqueueKeyedClaim(req *claimRequest) (int64, *claimError) {
+	tx := &models.Transaction{
+		WalletAddress: req.WalletAddress,
+		IPAddress:     req.ClientIP,
+		Asset:         req.Asset.Symbol,
+		Amount:        req.Amount,
+		Status:        "queued",
+		APIKeyID:      req.APIKey.ID,
+		Timestamp:     time.Now(),
+	}
+	txID, err := s.db.CreateKeyedTransaction(tx, req.APIKey.DailyQuota)
+	if err != nil {
+		log.Printf("[Claim] Failed to queue claim for API key %d: %v", req.APIKey.ID, err)
+		return 0, &claimError{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to record transaction",
+		}
+	}
+	if txID == 0 {
+		return 0, &claimError{
+			Status:  http.StatusTooManyRequests,
+			Message: "API key daily quota exhausted",
+		}
+	}
+
+	s.queue.wake()
+	return txID, nil
+}
+
+// enqueueIdentityClaim queues a claim by a signed-in user, within the
+// cooldown and daily cap of their identity
+func (s *Server) enqueueIdentityClaim(req *claimRequest) (int64, *claimError) {
+	key := req.Identity.Key()
+	tx := &models.Transaction{
+		WalletAddress: req.WalletAddress,
+		IPAddress:     req.ClientIP,
+		Asset:         req.Asset.Symbol,
+		Amount:        req.Amount,
+		Status:        "queued",
+		Identity:      key,
+		Timestamp:     time.Now(),
+	}
+	cooldown := s.cfg().Auth.Cooldown
+	dailyLimit := s.cfg().Auth.DailyClaims
+	txID, err := s.db.CreateIdentityTransaction(tx, dailyLimit, cooldown)
+	if err != nil {
+		log.Printf("[Claim] Failed to queue claim for %s: %v", key, err)
+		return 0, &claimError{
+			Status:  http.StatusInternalServerError,
+			Message: "Failed to record transaction",
+		}
+	}
+
+	if txID == 0 {
+		// Work out when the identity may claim again
+		window := time.Duration(cooldown) * time.Second
+		if window < 24*time.Hour {
+			window = 24 * time.Hour
+		}
+		now := time.Now()
+		claimTimes, err := s.db.GetIdentityClaimTimes(key, req.Asset.Symbol, now.Add(-window))
+		if err != nil {
+			log.Printf("[Claim] Error checking claim history for %s: %v", key, err)
+		}
+		nextClaimTime := nextAllowedClaim(claimTimes, time.Duration(cooldown)*time.Second, dailyLimit, now)
+		if nextClaimTime.IsZero() {
+			// The blocking claim failed in the meantime
+			nextClaimTime = now
+		}
+		return 0, &claimError{
+			Status:        http.StatusTooManyRequests,
+			Message:       fmt.Sprintf("Please wait until %s before requesting funds again", formatWaitTime(nextClaimTime)),
+			NextClaimTime: nextClaimTime,
+		}
+	}
+
+	s.queue.wake()
+	return txID, nil
+}
+
+// executeClaims sends a batch of queued claims and records the outcome. It
+// runs on the claim queue's workers. Batches are always native SOL; if the
+// batch transaction is rejected, each claim is retried on its own so one bad
+// recipient doesn't fail the others.
+func (s *Server) executeClaims(batch []*models.Transaction) {
+	if len(batch) == 1 {
+		s.executeClaim(batch[0])
+		return
+	}
+
+	transfers := make([]utils.SOLTransfer, len(batch))
+	for i, tx := range batch {
+		transfers[i] = utils.SOLTransfer{ToAddress: tx.WalletAddress, Amount: tx.Amount}
+	}
+
+	sent, err := s.solana.SendSOLBatch(transfers, s.recordSignature(batch...))
+	if err != nil && !errors.Is(err, utils.ErrOutcomeUnknown) {
+		log.Printf("[Claim] Batch of %d claims failed, retrying individually: %v", len(batch), err)
+		for _, tx := range batch {
+			s.executeClaim(tx)
+		}
+		return
+	}
+	if err != nil {
+		// The batch may have paid out; retrying could pay twice, so leave it
+		// pending for the confirmer to settle by its signature
+		log.Printf("[Claim] Batch of %d claims has an unknown outcome: %v", len(batch), err)
+	}
+
+	// Every claim shares the signature; split the fee between them
+	for i, tx := range batch {
+		fee := sent.FeeLamports / uint64(len(batch))
+		if i == 0 {
+			fee += sent.FeeLamports % uint64(len(batch))
+		}
+		s.recordSent(tx, &utils.SentTransaction{
+			Signature:            sent.Signature,
+			LastValidBlockHeight: sent.LastValidBlockHeight,
+			FeeLamports:          fee,
+		})
+	}
+}
+
+// executeClaim sends a single queued claim and records the outcome
+func (s *Server) executeClaim(tx *models.Transaction) {
+	asset := s.findAsset(tx.Asset)
+	if asset == nil {
+		s.failClaim(tx, fmt.Sprintf("asset %s is no longer offered", tx.Asset))
+		return
+	}
+
+	// Send transaction
+	var sent *utils.SentTransaction
+	var err error
+	if asset.isNative() {
+		sent, err = s.solana.SendSOL(tx.WalletAddress, tx.Amount, s.recordSignature(tx))
+	} else {
+		sent, err = s.solana.SendToken(tx.WalletAddress, asset.Mint, asset.Program, tx.Amount, s.recordSignature(tx))
+	}
+	if err != nil {
+		log.Printf("[Claim] Error sending transaction for claim %d: %v", tx.ID, err)
+		if !errors.Is(err, utils.ErrOutcomeUnknown) {
+			s.failClaim(tx, err.Error())
+			return
+		}
+		// It may have paid out; the confirmer settles it by its signature
+	}
+
+	s.recordSent(tx, sent)
+}
+
+// recordSignature returns a hook that saves the signature of a signed
+// transaction on the claims it pays before it's broadcast, so a send cut
+// short by a crash or restart can still be settled by the confirmer
+func (s *Server) recordSignature(batch ...*models.Transaction) utils.SignedFunc {
+	ids := make([]int64, len(batch))
+	for i, tx := range batch {
+		ids[i] = tx.ID
+	}
+	return func(sent *utils.SentTransaction) error {
+		return s.db.RecordSignature(ids, sent.Signature, sent.LastValidBlockHeight)
+	}
+}
+
+// recordSent stores the signature of a sent claim
+func (s *Server) recordSent(tx *models.Transaction, sent *utils.SentTransaction) {
+	// Attach the signature; the confirmer marks it completed or failed once
+	// the cluster has processed it
+	tx.Status = "pending"
+	tx.TxHash = sent.Signature
+	tx.LastValidBlockHeight = sent.LastValidBlockHeight
+	tx.FeeLamports = sent.FeeLamports
+	if err := s.db.UpdateTransaction(tx); err != nil {
+		log.Printf("[Claim] Failed to update transaction %d: %v", tx.ID, err)
+	}
+}
+
+// failClaim records a claim that could not be sent and releases its
+// cooldown reservation
+func (s *Server) failClaim(tx *models.Transaction, message string) {
+	tx.Status = "failed"
+	tx.ErrorMessage = message
+	if err := s.db.FailClaim(tx); err != nil {
+		log.Printf("[Claim] Failed to record failed claim %d: %v", tx.ID, err)
+	}
+}
+
+// subnetLimitError reports when a limited subnet may claim an asset again
+func (s *Server) subnetLimitError(clientIP, subnet, asset string, limits db.SubnetLimits) *claimError {
+	log.Printf("[Claim] IP limit reached for %s (subnet %s)", clientIP, subnet)
+
+	now := time.Now()
+	cooldown := time.Duration(limits.Cooldown) * time.Second
+	window := cooldown
+	if limits.DailyClaims > 0 && window < 24*time.Hour {
+		window = 24 * time.Hour
+	}
+	claimTimes, err := s.db.GetSubnetClaimTimes(subnet, asset, now.Add(-window))
+	if err != nil {
+		log.Printf("[Claim] Error checking subnet claim times: %v", err)
+	}
+
+	nextClaimTime := nextAllowedClaim(claimTimes, cooldown, limits.DailyClaims, now)
+	if nextClaimTime.IsZero() {
+		return &claimError{
+			Status:  http.StatusTooManyRequests,
+			Message: "Too many claims from your network. Please try again later",
+		}
+	}
+	return &claimError{
+		Status:        http.StatusTooManyRequests,
+		Message:       fmt.Sprintf("Too many claims from your network. Please wait until %s before requesting funds again", formatWaitTime(nextClaimTime)),
+		NextClaimTime: nextClaimTime,
+	}
+}
+
+// nextAllowedClaim returns when another claim is allowed given the times of
+// recent claims, oldest first, or the zero time if one is allowed now
+func nextAllowedClaim(claimTimes []time.Time, cooldown time.Duration, dailyLimit int, now time.Time) time.Time {
+	var nextClaimTime time.Time
+
+	// Cooldown since the most recent claim
+	if cooldown > 0 && len(claimTimes) > 0 {
+		if next := claimTimes[len(claimTimes)-1].Add(cooldown); next.After(now) {
+			nextClaimTime = next
+		}
+	}
+
+	// Daily cap: wait until enough of the last 24 hours' claims age out
+	if dailyLimit > 0 {
+		var daily []time.Time
+		for _, t := range claimTimes {
+			if now.Sub(t) < 24*time.Hour {
+				daily = append(daily, t)
+			}
+		}
+		if len(daily) >= dailyLimit {
+			if next := daily[len(daily)-dailyLimit].Add(24 * time.Hour); next.After(nextClaimTime) {
+				nextClaimTime = next
+			}
+		}
+	}
+
+	return nextClaimTime
+}
+
+// formatWaitTime renders the time until the next allowed claim for humans
+func formatWaitTime(nextClaimTime time.Time) string {
+	// Calculate wait time
+	waitTime := time.Until(nextClaimTime)
+	hours := int(waitTime.Hours())
+	minutes := int(waitTime.Minutes()) % 60
+	seconds := int(waitTime.Seconds()) % 60
+
+	if hours >= 2 {
+		// Format the next claim time as a date
+		return nextClaimTime.Format("Jan 2 at 3:04 PM")
+	} else if hours > 0 {
+		if minutes > 0 {
+			return fmt.Sprintf("%d hour%s and %d minute%s",
+				hours, pluralize(hours),
+				minutes, pluralize(minutes))
+		}
+		return fmt.Sprintf("%d hour%s", hours, pluralize(hours))
+	} else if minutes > 0 {
+		if seconds > 0 {
+			return fmt.Sprintf("%d minute%s and %d second%s",
+				minutes, pluralize(minutes),
+				seconds, pluralize(seconds))
+		}
+		return fmt.Sprintf("%d minute%s", minutes, pluralize(minutes))
+	}
+	return fmt.Sprintf("%d second%s", seconds, pluralize(seconds))
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the standard {"success": false, "error": ...} response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"error":   message,
+	})
+}
@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/maestroi/solana-faucet/backend/db"
+	"github.com/maestroi/solana-faucet/backend/utils"
+)
+
+// confirmBatchSize is the maximum number of signatures polled per
+// getSignatureStatuses call
+const confirmBatchSize = 256
+
+// confirmer polls pending transactions until they reach the configured
+// commitment or their blockhash expires, and records the final status.
+// State lives in the transactions table so pending sends survive restarts.
+type confirmer struct {
+	db         *db.Database
+	solana     *utils.SolanaClient
+	commitment rpc.CommitmentType
+	interval   time.Duration
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// newConfirmer creates a confirmer for the given commitment level
+func newConfirmer(database *db.Database, solanaClient *utils.SolanaClient, commitment string, interval time.Duration) *confirmer {
+	if interval <= 0 {
+		interval = 2 * time.Second
+	}
+	return &confirmer{
+		db:         database,
+		solana:     solanaClient,
+		commitment: rpc.CommitmentType(commitment),
+		interval:   interval,
+		stopCh:     make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// run polls until stop is called
+func (c *confirmer) run() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-ticker.C:
+			c.poll()
+		}
+	}
+}
+
+// stop ends the polling loop and waits for the current poll to finish
+func (c *confirmer) stop() {
+	c.stopOnce.Do(func() {
+		close(c.stopCh)
+	})
+	<-c.done
+}
+
+// poll checks one batch of pending transactions
+func (c *confirmer) poll() {
+	pending, err := c.db.GetPendingTransactions(confirmBatchSize)
+	if err != nil {
+		log.Printf("[Confirmer] Error loading pending transactions: %v", err)
+		return
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	// Claims sent in one batch share a signature; look each up once
+	var signatures []string
+	seen := make(map[string]bool, len(pending))
+	for _, tx := range pending {
+		if !seen[tx.TxHash] {
+			seen[tx.TxHash] = true
+			signatures = append(signatures, tx.TxHash)
+		}
+	}
+
+	statuses, err := c.solana.GetSignatureStatuses(signatures, false)
+	if err != nil {
+		log.Printf("[Confirmer] Error getting signature statuses: %v", err)
+		return
+	}
+	bySig := make(map[string]*rpc.SignatureStatusesResult, len(signatures))
+	for i, sig := range signatures {
+		if i < len(statuses.Value) {
+			bySig[sig] = statuses.Value[i]
+		}
+	}
+
+	// Only fetched when something is still unseen, to check for expiry
+	var blockHeight uint64
+	var haveBlockHeight bool
+	// Signatures already looked up in the full transaction history
+	searched := make(map[string]bool)
+
+	for _, tx := range pending {
+		status := bySig[tx.TxHash]
+
+		if status == nil {
+			// Not seen by the cluster; wait until its blockhash has expired
+			if tx.LastValidBlockHeight == 0 {
+				continue
+			}
+			if !haveBlockHeight {
+				blockHeight, err = c.solana.GetBlockHeight(rpc.CommitmentConfirmed)
+				if err != nil {
+					return
+				}
+				haveBlockHeight = true
+			}
+			if blockHeight <= tx.LastValidBlockHeight {
+				continue
+			}
+			// The recent status cache can miss a transaction that landed;
+			// search the full history before giving up on it
+			if !searched[tx.TxHash] {
+				searched[tx.TxHash] = true
+				history, err := c.solana.GetSignatureStatuses([]string{tx.TxHash}, true)
+				if err != nil {
+					log.Printf("[Confirmer] Error searching history for %s: %v", tx.TxHash, err)
+					continue
+				}
+				if len(history.Value) > 0 && history.Value[0] != nil {
+					status = history.Value[0]
+					bySig[tx.TxHash] = status
+				}
+			}
+		}
+
+		switch {
+		case status == nil:
+			tx.Status = "failed"
+			tx.ErrorMessage = "blockhash expired before the transaction was confirmed"
+
+		case status.Err != nil:
+			tx.Status = "failed"
+			tx.ErrorMessage = formatTransactionError(status.Err)
+
+		case reachedCommitment(status.ConfirmationStatus, c.commitment):
+			tx.Status = "completed"
+
+		default:
+			// Landed but not yet at the required commitment
+			continue
+		}
+
+		update := c.db.UpdateTransaction
+		if tx.Status == "failed" {
+			// Releases an anonymous claim's cooldown along with the update
+			update = c.db.FailClaim
+		}
+		if err := update(tx); err != nil {
+			log.Printf("[Confirmer] Failed to update transaction %d: %v", tx.ID, err)
+			continue
+		}
+		log.Printf("[Confirmer] Transaction %s %s", tx.TxHash, tx.Status)
+	}
+}
+
+// reachedCommitment reports whether a confirmation status satisfies the
+// required commitment level
+func reachedCommitment(status rpc.ConfirmationStatusType, commitment rpc.CommitmentType) bool {
+	levels := map[string]int{
+		"processed": 1,
+		"confirmed": 2,
+		"finalized": 3,
+	}
+	required, ok := levels[string(commitment)]
+	if !ok {
+		required = levels["confirmed"]
+	}
+	return levels[string(status)] >= required
+}
+
+// formatTransactionError renders an on-chain transaction error for storage
+func formatTransactionError(txErr interface{}) string {
+	data, err := json.Marshal(txErr)
+	if err != nil {
+		return fmt.Sprintf("%v", txErr)
+	}
+	return string(data)
+}
@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/maestroi/solana-faucet/backend/models"
 	"github.com/maestroi/solana-faucet/backend/utils"
 )
@@ -22,22 +24,32 @@ const balanceCacheDuration = 1 * time.Minute
 
 // handleGetBalance returns the current balance of the faucet wallet
 func (s *Server) handleGetBalance(w http.ResponseWriter, r *http.Request) {
-	log.Printf("[Balance] Starting balance request from %s", r.RemoteAddr)
+	log.Printf("[Balance] Starting balance request from %s", clientIPFromRequest(r))
 
+	balance, cached, err := s.faucetBalance()
+	if err != nil {
+		http.Error(w, "Failed to get balance", http.StatusInternalServerError)
+		return
+	}
+
+	response := BalanceResponse{
+		Balance: balance,
+		Cached:  cached,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// faucetBalance returns the faucet balance in SOL, from the cache when it is
+// fresh enough, and reports whether the cached value was used
+func (s *Server) faucetBalance() (float64, bool, error) {
 	s.balanceMutex.RLock()
 	// Check if we have a cached balance that's less than 1 minute old
 	if !s.lastBalanceTime.IsZero() && time.Since(s.lastBalanceTime) < balanceCacheDuration {
 		balance := s.cachedBalance
 		s.balanceMutex.RUnlock()
 		log.Printf("[Balance] Returning cached balance: %f SOL", balance)
-
-		response := BalanceResponse{
-			Balance: balance,
-			Cached:  true,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-		return
+		return balance, true, nil
 	}
 	s.balanceMutex.RUnlock()
 
@@ -48,21 +60,14 @@ func (s *Server) handleGetBalance(w http.ResponseWriter, r *http.Request) {
 	// Double check if another request already updated the cache
 	if !s.lastBalanceTime.IsZero() && time.Since(s.lastBalanceTime) < balanceCacheDuration {
 		log.Printf("[Balance] Another request updated cache, returning cached balance: %f SOL", s.cachedBalance)
-		response := BalanceResponse{
-			Balance: s.cachedBalance,
-			Cached:  true,
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-		return
+		return s.cachedBalance, true, nil
 	}
 
 	log.Printf("[Balance] Fetching fresh balance from Solana")
 	balance, err := s.solana.GetFaucetBalance()
 	if err != nil {
 		log.Printf("[Balance] Error getting balance: %v", err)
-		http.Error(w, "Failed to get balance", http.StatusInternalServerError)
-		return
+		return 0, false, err
 	}
 	log.Printf("[Balance] Got raw balance in lamports: %d", balance)
 
@@ -73,13 +78,7 @@ func (s *Server) handleGetBalance(w http.ResponseWriter, r *http.Request) {
 	s.cachedBalance = balanceSOL
 	s.lastBalanceTime = time.Now()
 
-	response := BalanceResponse{
-		Balance: balanceSOL,
-		Cached:  false,
-	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
-	log.Printf("[Balance] Successfully returned fresh balance")
+	return balanceSOL, false, nil
 }
 
 // handleRequestFunds handles the request funds endpoint
@@ -88,13 +87,7 @@ func (s *Server) handleRequestFunds(w http.ResponseWriter, r *http.Request) {
 	var req models.FundRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("[RequestFunds] Invalid request body: %v", err)
-		response := map[string]interface{}{
-			"success": false,
-			"error":   "Invalid request format",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, http.StatusBadRequest, "Invalid request format")
 		return
 	}
 
@@ -103,171 +96,171 @@ func (s *Server) handleRequestFunds(w http.ResponseWriter, r *http.Request) {
 
 	// Validate required fields
 	if req.WalletAddress == "" {
-		response := map[string]interface{}{
-			"success": false,
-			"error":   "Wallet address is required",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, http.StatusBadRequest, "Wallet address is required")
 		return
 	}
 
-	if req.TurnstileResponse == "" {
-		response := map[string]interface{}{
-			"success": false,
-			"error":   "Turnstile response is required",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
-		return
-	}
-
-	// Get client IP
-	clientIP := r.RemoteAddr
-	if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-		clientIP = forwardedFor
-	}
-
-	// Validate Turnstile token
-	if s.turnstile != nil {
-		isValid, err := s.turnstile.VerifyToken(req.TurnstileResponse)
+	// API keys stand in for the captcha and carry their own limits
+	var apiKey *models.APIKey
+	if key := apiKeyFromRequest(r); key != "" {
+		var err error
+		apiKey, err = s.authenticateAPIKey(key)
 		if err != nil {
-			log.Printf("[RequestFunds] Turnstile verification error: %v", err)
-			response := map[string]interface{}{
-				"success": false,
-				"error":   "Failed to verify Turnstile token",
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(response)
+			log.Printf("[RequestFunds] Error checking API key: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to check API key")
 			return
 		}
-		if !isValid {
-			response := map[string]interface{}{
-				"success": false,
-				"error":   "Invalid Turnstile token",
-			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			json.NewEncoder(w).Encode(response)
+		if apiKey == nil {
+			writeError(w, http.StatusUnauthorized, "Invalid API key")
+			return
+		}
+		if !models.Allows(apiKey.Networks, s.cfg().Solana.NetworkType) {
+			writeError(w, http.StatusForbidden, "API key is not allowed on this network")
 			return
 		}
 	}
 
+	// Signed-in users get the higher allowance; API keys take precedence
+	identity := s.sessionIdentity(r)
+	if apiKey != nil {
+		identity = nil
+	}
+
+	captchaToken := req.CaptchaToken()
+	usePow := s.pow != nil && req.PowChallenge != ""
+	captchaRequired := s.cfg().Security.CaptchaProvider != utils.CaptchaNone && !s.cfg().Security.CaptchaDevBypass
+	if captchaToken == "" && !usePow && captchaRequired && apiKey == nil {
+		writeError(w, http.StatusBadRequest, "Captcha response is required")
+		return
+	}
+
+	// Get client IP
+	clientIP := clientIPFromRequest(r)
+
 	// Validate wallet address
 	if !utils.IsValidSolanaAddress(req.WalletAddress) {
-		response := map[string]interface{}{
-			"success": false,
-			"error":   "Invalid Solana wallet address format",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(response)
+		writeError(w, http.StatusBadRequest, "Invalid Solana wallet address format")
 		return
 	}
 
-	// Check if this wallet has claimed recently
-	history, err := s.db.GetClaimHistory(req.WalletAddress)
-	if err != nil {
-		log.Printf("[RequestFunds] Error checking claim history: %v", err)
-		response := map[string]interface{}{
-			"success": false,
-			"error":   "Failed to check claim history",
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+	// Look up the requested asset
+	asset := s.findAsset(req.Asset)
+	if asset == nil {
+		writeError(w, http.StatusBadRequest, "Unsupported asset")
 		return
 	}
 
-	if history != nil {
-		// Check if the wallet can claim again
-		canClaim, nextClaimTime := history.CanClaim(s.config.Security.ClaimCooldown)
-		if !canClaim {
-			// Calculate wait time
-			waitTime := time.Until(nextClaimTime)
-			hours := int(waitTime.Hours())
-			minutes := int(waitTime.Minutes()) % 60
-			seconds := int(waitTime.Seconds()) % 60
-
-			var timeMsg string
-			if hours >= 2 {
-				// Format the next claim time as a date
-				timeMsg = nextClaimTime.Format("Jan 2 at 3:04 PM")
-			} else if hours > 0 {
-				if minutes > 0 {
-					timeMsg = fmt.Sprintf("%d hour%s and %d minute%s",
-						hours, pluralize(hours),
-						minutes, pluralize(minutes))
-				} else {
-					timeMsg = fmt.Sprintf("%d hour%s", hours, pluralize(hours))
-				}
-			} else if minutes > 0 {
-				if seconds > 0 {
-					timeMsg = fmt.Sprintf("%d minute%s and %d second%s",
-						minutes, pluralize(minutes),
-						seconds, pluralize(seconds))
-				} else {
-					timeMsg = fmt.Sprintf("%d minute%s", minutes, pluralize(minutes))
-				}
-			} else {
-				timeMsg = fmt.Sprintf("%d second%s", seconds, pluralize(seconds))
-			}
+	amount := asset.Amount
+	if apiKey != nil {
+		if !models.Allows(apiKey.Assets, asset.Symbol) {
+			writeError(w, http.StatusForbidden, fmt.Sprintf("API key is not allowed to claim %s", asset.Symbol))
+			return
+		}
+		if asset.isNative() && apiKey.Amount > 0 {
+			amount = apiKey.Amount
+		}
+	} else if identity != nil && asset.isNative() && s.cfg().Auth.Amount > 0 {
+		amount = s.cfg().Auth.Amount
+	}
 
-			response := map[string]interface{}{
-				"success":       false,
-				"error":         fmt.Sprintf("Please wait until %s before requesting funds again", timeMsg),
-				"nextClaimTime": nextClaimTime.Unix(),
+	// Retries carrying an Idempotency-Key get the original claim back. This
+	// runs before the captcha check since a retry resends an already used
+	// token.
+	idempotencyKey := r.Header.Get("Idempotency-Key")
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		writeError(w, http.StatusBadRequest, "Idempotency-Key is too long")
+		return
+	}
+	if idempotencyKey != "" {
+		if s.replayIdempotentClaim(w, idempotencyKey, req.WalletAddress, asset.Symbol) {
+			return
+		}
+		// Free the key again unless a claim gets attached to it
+		defer func() {
+			if err := s.db.DeleteIdempotencyKey(idempotencyKey); err != nil {
+				log.Printf("[RequestFunds] Failed to release idempotency key: %v", err)
 			}
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusTooManyRequests)
-			json.NewEncoder(w).Encode(response)
+		}()
+	}
+
+	// Validate the proof-of-work solution or the captcha token
+	if apiKey != nil {
+		log.Printf("[RequestFunds] Authenticated with API key %d (%s)", apiKey.ID, apiKey.Name)
+	} else if usePow {
+		if err := s.pow.Verify(req.PowChallenge, req.WalletAddress, req.PowNonce); err != nil {
+			log.Printf("[RequestFunds] Proof-of-work rejected: %v", err)
+			writeError(w, http.StatusBadRequest, "Invalid proof-of-work: "+err.Error())
+			return
+		}
+	} else if captcha := s.state.Load().captcha; captcha != nil {
+		isValid, err := captcha.Verify(r.Context(), captchaToken, clientIP)
+		if err != nil {
+			log.Printf("[RequestFunds] Captcha verification error: %v", err)
+			writeError(w, http.StatusBadRequest, "Failed to verify captcha")
+			return
+		}
+		if !isValid {
+			writeError(w, http.StatusBadRequest, "Invalid captcha")
 			return
 		}
 	}
 
-	// Send transaction
-	txHash, err := s.solana.SendSOL(req.WalletAddress, s.config.Solana.AmountPerRequest)
-	if err != nil {
-		log.Printf("[RequestFunds] Error sending transaction: %v", err)
+	// Enforce cooldowns and queue the claim
+	claimID, claimErr := s.enqueueClaim(&claimRequest{
+		WalletAddress: req.WalletAddress,
+		ClientIP:      clientIP,
+		Asset:         asset,
+		Amount:        amount,
+		APIKey:        apiKey,
+		Identity:      identity,
+	})
+	if claimErr != nil {
 		response := map[string]interface{}{
 			"success": false,
-			"error":   "Failed to send transaction",
+			"error":   claimErr.Message,
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(response)
+		if !claimErr.NextClaimTime.IsZero() {
+			response["nextClaimTime"] = claimErr.NextClaimTime.Unix()
+		}
+		writeJSON(w, claimErr.Status, response)
 		return
 	}
 
-	// Record transaction in database
-	tx := &models.Transaction{
-		WalletAddress: req.WalletAddress,
-		IPAddress:     clientIP,
-		Amount:        s.config.Solana.AmountPerRequest,
-		Status:        "completed",
-		TxHash:        txHash,
-		Timestamp:     time.Now(),
-	}
-	if _, err := s.db.CreateTransaction(tx); err != nil {
-		log.Printf("[RequestFunds] Failed to save transaction: %v", err)
+	if idempotencyKey != "" {
+		if err := s.db.CompleteIdempotencyKey(idempotencyKey, claimID); err != nil {
+			log.Printf("[RequestFunds] Failed to store idempotency key: %v", err)
+		}
 	}
 
-	// Update claim history
-	if err := s.db.UpdateClaimHistory(req.WalletAddress, clientIP); err != nil {
-		log.Printf("[RequestFunds] Failed to update claim history: %v", err)
+	// The claim is sent by the queue workers; clients poll its status
+	writeJSON(w, http.StatusAccepted, claimResponse(&models.Transaction{
+		ID:     claimID,
+		Status: "queued",
+		Amount: amount,
+		Asset:  asset.Symbol,
+	}))
+}
+
+// handleGetClaim returns the status of a queued claim
+func (s *Server) handleGetClaim(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid claim ID")
+		return
 	}
 
-	// Return success response
-	response := map[string]interface{}{
-		"success":          true,
-		"amount":           s.config.Solana.AmountPerRequest,
-		"transaction_hash": txHash,
+	tx, err := s.db.GetTransaction(id)
+	if err != nil {
+		log.Printf("[Claims] Error getting claim %d: %v", id, err)
+		writeError(w, http.StatusInternalServerError, "Failed to get claim")
+		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	if tx == nil {
+		writeError(w, http.StatusNotFound, "Claim not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, claimResponse(tx))
 }
 
 // Helper function to add 's' for plurals
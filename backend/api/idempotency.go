@@ -0,0 +1,71 @@
+package api
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/maestroi/solana-faucet/backend/models"
+)
+
+// maxIdempotencyKeyLength bounds the keys clients may send
+const maxIdempotencyKeyLength = 255
+
+// replayIdempotentClaim reserves an Idempotency-Key for a fund request. If
+// the key was already used within the replay window it writes the original
+// claim back to the client and returns true; otherwise the caller goes on to
+// create the claim and must complete or release the key.
+func (s *Server) replayIdempotentClaim(w http.ResponseWriter, key, walletAddress, asset string) bool {
+	window := time.Duration(s.cfg().Security.IdempotencyWindow) * time.Second
+	record, created, err := s.db.ReserveIdempotencyKey(key, walletAddress, asset, window)
+	if err != nil {
+		log.Printf("[RequestFunds] Error reserving idempotency key: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to process request")
+		return true
+	}
+	if created {
+		return false
+	}
+
+	// A key belongs to exactly one request
+	if record.WalletAddress != walletAddress || record.Asset != asset {
+		writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		return true
+	}
+	if record.TransactionID == 0 {
+		writeError(w, http.StatusConflict, "A request with this Idempotency-Key is already in progress")
+		return true
+	}
+
+	tx, err := s.db.GetTransaction(record.TransactionID)
+	if err != nil || tx == nil {
+		log.Printf("[RequestFunds] Error loading claim %d for idempotency key: %v", record.TransactionID, err)
+		writeError(w, http.StatusInternalServerError, "Failed to process request")
+		return true
+	}
+
+	log.Printf("[RequestFunds] Replaying claim %d for idempotency key", tx.ID)
+	w.Header().Set("Idempotent-Replayed", "true")
+	writeJSON(w, http.StatusAccepted, claimResponse(tx))
+	return true
+}
+
+// claimResponse renders a claim for the request-funds and claim status
+// endpoints. A failed claim, such as one replayed for a retried request,
+// isn't reported as a success.
+func claimResponse(tx *models.Transaction) map[string]interface{} {
+	response := map[string]interface{}{
+		"success":  tx.Status != "failed",
+		"claim_id": tx.ID,
+		"status":   tx.Status,
+		"amount":   tx.Amount,
+		"asset":    tx.Asset,
+	}
+	if tx.TxHash != "" {
+		response["transaction_hash"] = tx.TxHash
+	}
+	if tx.ErrorMessage != "" {
+		response["error"] = tx.ErrorMessage
+	}
+	return response
+}
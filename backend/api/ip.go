@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// clientIPKey is the request context key holding the resolved client IP
+type clientIPKey struct{}
+
+// cloudflareHeaders are set by Cloudflare's edge and only believed when the
+// request came from a configured Cloudflare address
+var cloudflareHeaders = map[string]bool{
+	"Cf-Connecting-Ip": true,
+	"True-Client-Ip":   true,
+}
+
+// ipResolver determines the real client IP of a request. Forwarding headers
+// are only honoured when the request came from a trusted proxy, and address
+// chains are walked from the right so entries a client prepends are ignored.
+type ipResolver struct {
+	trusted    []*net.IPNet
+	cloudflare []*net.IPNet
+	headers    []string
+}
+
+// newIPResolver creates a resolver trusting the given proxy CIDRs (or bare
+// addresses) and consulting the given headers in order. Cloudflare's
+// headers are only honoured from the cloudflare CIDRs.
+func newIPResolver(trustedProxies, cloudflareProxies, headers []string) (*ipResolver, error) {
+	resolver := &ipResolver{}
+
+	var err error
+	if resolver.trusted, err = parseNetworks(trustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy %w", err)
+	}
+	if resolver.cloudflare, err = parseNetworks(cloudflareProxies); err != nil {
+		return nil, fmt.Errorf("invalid Cloudflare proxy %w", err)
+	}
+
+	for _, header := range headers {
+		if header = strings.TrimSpace(header); header != "" {
+			resolver.headers = append(resolver.headers, http.CanonicalHeaderKey(header))
+		}
+	}
+
+	return resolver, nil
+}
+
+// parseNetworks parses CIDRs or bare addresses
+func parseNetworks(entries []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("%q", entry)
+			}
+			if ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", entry, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// isTrusted reports whether ip belongs to a trusted proxy
+func (res *ipResolver) isTrusted(ip net.IP) bool {
+	return containsIP(res.trusted, ip)
+}
+
+// containsIP reports whether ip belongs to any of the networks
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolve returns the client IP for a request
+func (res *ipResolver) resolve(r *http.Request) string {
+	remote := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+
+	remoteIP := net.ParseIP(remote)
+	if remoteIP == nil {
+		return remote
+	}
+	trusted := res.isTrusted(remoteIP)
+	fromCloudflare := containsIP(res.cloudflare, remoteIP)
+	if !trusted && !fromCloudflare {
+		return remote
+	}
+
+	for _, header := range res.headers {
+		// Anyone can send Cloudflare's headers through a proxy that passes
+		// them on, so only Cloudflare itself is believed
+		if cloudflareHeaders[header] && !fromCloudflare || !cloudflareHeaders[header] && !trusted {
+			continue
+		}
+		values := r.Header.Values(header)
+		if len(values) == 0 {
+			continue
+		}
+
+		var chain []string
+		switch header {
+		case "Forwarded":
+			chain = parseForwarded(values)
+		case "X-Forwarded-For":
+			for _, value := range values {
+				chain = append(chain, strings.Split(value, ",")...)
+			}
+		default:
+			// Single-value headers such as CF-Connecting-IP and X-Real-IP
+			chain = []string{values[len(values)-1]}
+		}
+
+		if cloudflareHeaders[header] {
+			if ip := parseIPWithPort(chain[0]); ip != nil {
+				return ip.String()
+			}
+			continue
+		}
+		if ip := res.clientFromChain(chain); ip != nil {
+			return ip.String()
+		}
+	}
+
+	return remote
+}
+
+// clientFromChain returns the right-most address in a proxy chain that isn't
+// a trusted proxy, or the left-most one if every hop is trusted
+func (res *ipResolver) clientFromChain(chain []string) net.IP {
+	var leftmost net.IP
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip := parseIPWithPort(chain[i])
+		if ip == nil {
+			// An unparseable hop means we can't trust anything further left
+			return leftmost
+		}
+		if !res.isTrusted(ip) {
+			return ip
+		}
+		leftmost = ip
+	}
+	return leftmost
+}
+
+// parseForwarded extracts the for= addresses from RFC 7239 Forwarded headers
+func parseForwarded(values []string) []string {
+	var chain []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(key, "for") {
+					continue
+				}
+				chain = append(chain, strings.Trim(val, `"`))
+			}
+		}
+	}
+	return chain
+}
+
+// parseIPWithPort parses an address that may carry a port or IPv6 brackets
+func parseIPWithPort(value string) net.IP {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	return net.ParseIP(value)
+}
+
+// clientIPMiddleware resolves the client IP once and stores it in the request
+// context for handlers, logging and rate limiting
+func (s *Server) clientIPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), clientIPKey{}, s.ipResolver.resolve(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientIPFromRequest returns the client IP resolved by clientIPMiddleware
+func clientIPFromRequest(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientSubnet returns the network a client IP is aggregated into for claim
+// limits, using the configured IPv4 and IPv6 prefix lengths. It returns nil
+// if the address can't be parsed.
+func clientSubnet(clientIP string, ipv4Prefix, ipv6Prefix int) *net.IPNet {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return nil
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		mask := net.CIDRMask(clampPrefix(ipv4Prefix, 32), 32)
+		return &net.IPNet{IP: ip4.Mask(mask), Mask: mask}
+	}
+
+	mask := net.CIDRMask(clampPrefix(ipv6Prefix, 128), 128)
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+}
+
+// clampPrefix keeps a configured prefix length within [0, bits], treating
+// unset values as a single address
+func clampPrefix(prefix, bits int) int {
+	if prefix <= 0 || prefix > bits {
+		return bits
+	}
+	return prefix
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	resolver, err := newIPResolver(
+		[]string{"10.0.0.0/8"},
+		[]string{"173.245.48.0/20"},
+		[]string{"CF-Connecting-IP", "X-Forwarded-For", "Forwarded"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{
+			name:   "direct client",
+			remote: "203.0.113.7:4000",
+			want:   "203.0.113.7",
+		},
+		{
+			name:    "headers from an untrusted peer are ignored",
+			remote:  "203.0.113.7:4000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "forwarded by a trusted proxy",
+			remote:  "10.0.0.2:4000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "client-prepended entries are skipped",
+			remote:  "10.0.0.2:4000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.3"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "unparseable hop stops the walk",
+			remote:  "10.0.0.2:4000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1, bogus, 10.0.0.3"},
+			want:    "10.0.0.3",
+		},
+		{
+			name:    "tunnel through cloudflared and nginx",
+			remote:  "10.0.0.2:4000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.5"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "RFC 7239 Forwarded",
+			remote:  "10.0.0.2:4000",
+			headers: map[string]string{"Forwarded": `for="[2001:db8::1]:80";proto=https`},
+			want:    "2001:db8::1",
+		},
+		{
+			name:    "Cloudflare header through a trusted proxy is ignored",
+			remote:  "10.0.0.2:4000",
+			headers: map[string]string{"CF-Connecting-IP": "1.2.3.4", "X-Forwarded-For": "198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "Cloudflare header from Cloudflare",
+			remote:  "173.245.48.10:4000",
+			headers: map[string]string{"CF-Connecting-IP": "198.51.100.9"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "forwarding headers from Cloudflare aren't trusted",
+			remote:  "173.245.48.10:4000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			want:    "173.245.48.10",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if got := resolver.resolve(r); got != tt.want {
+				t.Fatalf("resolve = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewIPResolverRejectsInvalidProxies(t *testing.T) {
+	if _, err := newIPResolver([]string{"not-an-ip"}, nil, nil); err == nil {
+		t.Fatal("invalid trusted proxy was accepted")
+	}
+	if _, err := newIPResolver(nil, []string{"10.0.0.0/99"}, nil); err == nil {
+		t.Fatal("invalid Cloudflare proxy was accepted")
+	}
+}
+
+func TestClientSubnet(t *testing.T) {
+	tests := []struct {
+		ip         string
+		ipv4, ipv6 int
+		want       string
+	}{
+		{"203.0.113.7", 24, 64, "203.0.113.0/24"},
+		{"203.0.113.7", 0, 64, "203.0.113.7/32"},
+		{"2001:db8:1:2:3::1", 24, 48, "2001:db8:1::/48"},
+		{"2001:db8::1", 24, 200, "2001:db8::1/128"},
+	}
+	for _, tt := range tests {
+		if got := clientSubnet(tt.ip, tt.ipv4, tt.ipv6).String(); got != tt.want {
+			t.Errorf("clientSubnet(%s, %d, %d) = %s, want %s", tt.ip, tt.ipv4, tt.ipv6, got, tt.want)
+		}
+	}
+	if clientSubnet("bogus", 24, 64) != nil {
+		t.Error("clientSubnet of an invalid address isn't nil")
+	}
+}
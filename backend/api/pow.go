@@ -0,0 +1,81 @@
+package api
+
+import (
+	"log"
+	"math/bits"
+	"net/http"
+	"time"
+
+	"github.com/maestroi/solana-faucet/backend/utils"
+)
+
+// Faucet balance, in claims left, below which the PoW difficulty rises
+const (
+	powLowBalanceClaims      = 100
+	powCriticalBalanceClaims = 10
+)
+
+// handlePowChallenge issues a proof-of-work challenge for a wallet, which
+// can be solved and sent with a fund request instead of a captcha token
+func (s *Server) handlePowChallenge(w http.ResponseWriter, r *http.Request) {
+	if s.pow == nil {
+		writeError(w, http.StatusNotFound, "Proof-of-work is not enabled")
+		return
+	}
+
+	wallet := r.URL.Query().Get("wallet")
+	if !utils.IsValidSolanaAddress(wallet) {
+		writeError(w, http.StatusBadRequest, "Invalid Solana wallet address format")
+		return
+	}
+
+	challenge, err := s.pow.Issue(wallet, s.powDifficulty())
+	if err != nil {
+		log.Printf("[PoW] Error issuing challenge: %v", err)
+		writeError(w, http.StatusInternalServerError, "Failed to issue challenge")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":    true,
+		"challenge":  challenge.Challenge,
+		"difficulty": challenge.Difficulty,
+		"expires_at": challenge.ExpiresAt.Unix(),
+	})
+}
+
+// powDifficulty scales the configured difficulty with demand: one extra bit,
+// doubling the expected work, each time the claims in the last hour double
+// past the volume threshold, and more as the faucet runs dry
+func (s *Server) powDifficulty() int {
+	cfg := s.cfg().Security
+	difficulty := cfg.PowDifficulty
+
+	// Recent claim volume
+	if cfg.PowVolumeThreshold > 0 {
+		claims, err := s.db.CountTransactionsSince(time.Now().Add(-time.Hour))
+		if err != nil {
+			log.Printf("[PoW] Error counting recent claims: %v", err)
+		} else if claims >= cfg.PowVolumeThreshold {
+			difficulty += bits.Len(uint(claims / cfg.PowVolumeThreshold))
+		}
+	}
+
+	// Faucet balance
+	if perClaim := s.cfg().Solana.AmountPerRequest; perClaim > 0 {
+		balance, _, err := s.faucetBalance()
+		if err == nil {
+			switch claimsLeft := balance / perClaim; {
+			case claimsLeft < powCriticalBalanceClaims:
+				difficulty += 4
+			case claimsLeft < powLowBalanceClaims:
+				difficulty += 2
+			}
+		}
+	}
+
+	if cfg.PowMaxDifficulty > 0 && difficulty > cfg.PowMaxDifficulty {
+		difficulty = cfg.PowMaxDifficulty
+	}
+	return difficulty
+}
@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/maestroi/solana-faucet/backend/db"
+	"github.com/maestroi/solana-faucet/backend/models"
+	"github.com/maestroi/solana-faucet/backend/utils"
+)
+
+// claimQueue sends queued claims on a fixed pool of workers, so a slow or
+// congested cluster backs up the queue instead of holding HTTP requests
+// open. The queue itself is the transactions table: claims are inserted as
+// "queued" and survive restarts.
+//
+// Native SOL claims waiting together are handed to execute as one batch of
+// up to batchSize claims, which is sent as a single transaction.
+type claimQueue struct {
+	db           *db.Database
+	workers      int
+	batchSize    int
+	pollInterval time.Duration
+	execute      func(batch []*models.Transaction)
+
+	wakeCh   chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+
+	// Callers waiting for a claim to leave the queue, by claim ID
+	waitersMutex sync.Mutex
+	waiters      map[int64][]chan struct{}
+}
+
+// newClaimQueue creates a queue that hands claims to execute
+func newClaimQueue(database *db.Database, workers, batchSize int, pollInterval time.Duration, execute func(batch []*models.Transaction)) *claimQueue {
+	if workers <= 0 {
+		workers = 1
+	}
+	if batchSize <= 0 {
+		batchSize = 1
+	}
+	if batchSize > utils.MaxSOLBatchSize {
+		batchSize = utils.MaxSOLBatchSize
+	}
+	if pollInterval <= 0 {
+		pollInterval = time.Second
+	}
+	return &claimQueue{
+		db:           database,
+		workers:      workers,
+		batchSize:    batchSize,
+		pollInterval: pollInterval,
+		execute:      execute,
+		wakeCh:       make(chan struct{}, workers),
+		stopCh:       make(chan struct{}),
+		waiters:      make(map[int64][]chan struct{}),
+	}
+}
+
+// start launches the workers
+func (q *claimQueue) start() {
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+}
+
+// stop tells the workers to exit and waits for in-flight sends to finish,
+// or until ctx is done. Sends still running then are abandoned; their claims
+// stay in "sending" and are reconciled on the next start.
+func (q *claimQueue) stop(ctx context.Context) {
+	q.stopOnce.Do(func() {
+		close(q.stopCh)
+	})
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("[Queue] Gave up waiting for in-flight sends; they will be reconciled on restart")
+	}
+}
+
+// wake nudges an idle worker to check the queue without waiting for the
+// next poll
+func (q *claimQueue) wake() {
+	select {
+	case q.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// work sends claims until the queue is empty, then sleeps until woken
+func (q *claimQueue) work() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		q.drain()
+
+		select {
+		case <-q.stopCh:
+			return
+		case <-q.wakeCh:
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain sends queued claims until none are left or the queue is stopping
+func (q *claimQueue) drain() {
+	for {
+		select {
+		case <-q.stopCh:
+			return
+		default:
+		}
+
+		tx, err := q.db.ClaimNextQueuedTransaction()
+		if err != nil {
+			log.Printf("[Queue] Error taking next claim: %v", err)
+			return
+		}
+		if tx == nil {
+			return
+		}
+
+		// Fill a batch with other SOL claims waiting behind this one
+		batch := []*models.Transaction{tx}
+		if tx.Asset == nativeAsset && q.batchSize > 1 {
+			more, err := q.db.ClaimQueuedTransactions(tx.Asset, q.batchSize-1)
+			if err != nil {
+				log.Printf("[Queue] Error filling batch: %v", err)
+			}
+			batch = append(batch, more...)
+		}
+
+		log.Printf("[Queue] Sending %d claim(s) starting at %d", len(batch), tx.ID)
+		q.execute(batch)
+		for _, t := range batch {
+			q.notify(t.ID)
+		}
+	}
+}
+
+// notify releases everyone waiting on a claim
+func (q *claimQueue) notify(id int64) {
+	q.waitersMutex.Lock()
+	waiters := q.waiters[id]
+	delete(q.waiters, id)
+	q.waitersMutex.Unlock()
+
+	for _, ch := range waiters {
+		close(ch)
+	}
+}
+
+// wait blocks until a claim has been sent or failed, or the timeout passes,
+// and returns its latest state
+func (q *claimQueue) wait(id int64, timeout time.Duration) (*models.Transaction, error) {
+	ch := make(chan struct{})
+	q.waitersMutex.Lock()
+	q.waiters[id] = append(q.waiters[id], ch)
+	q.waitersMutex.Unlock()
+
+	// The claim may have been sent before we registered
+	tx, err := q.db.GetTransaction(id)
+	if err != nil || tx == nil || !isQueuedStatus(tx.Status) {
+		q.removeWaiter(id, ch)
+		return tx, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-ch:
+	case <-timer.C:
+		q.removeWaiter(id, ch)
+	}
+
+	return q.db.GetTransaction(id)
+}
+
+// removeWaiter unregisters a waiter that gave up
+func (q *claimQueue) removeWaiter(id int64, ch chan struct{}) {
+	q.waitersMutex.Lock()
+	defer q.waitersMutex.Unlock()
+
+	waiters := q.waiters[id]
+	for i, w := range waiters {
+		if w == ch {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(q.waiters, id)
+	} else {
+		q.waiters[id] = waiters
+	}
+}
+
+// isQueuedStatus reports whether a claim has not been sent yet
+func isQueuedStatus(status string) bool {
+	return status == "queued" || status == "sending"
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/go-chi/cors"
+	"github.com/maestroi/solana-faucet/backend/config"
+	"github.com/maestroi/solana-faucet/backend/utils"
+)
+
+// serverState is the configuration and everything the server builds from
+// it that a reload replaces. It is swapped as a whole, so a request sees
+// either the old or the new settings, never a mix.
+type serverState struct {
+	config  *config.Config
+	assets  []*faucetAsset
+	captcha utils.CaptchaVerifier
+	cors    *cors.Cors // applied to every route
+	apiCors *cors.Cors // applied to the API routes
+}
+
+// newServerState builds the reloadable parts of the server from cfg
+func newServerState(cfg *config.Config) (*serverState, error) {
+	// Create captcha verifier
+	captcha, err := utils.NewCaptchaVerifier(utils.CaptchaOptions{
+		Provider:  cfg.Security.CaptchaProvider,
+		SecretKey: cfg.Security.CaptchaSecretKey,
+		VerifyURL: cfg.Security.CaptchaVerifyURL,
+		MinScore:  cfg.Security.CaptchaMinScore,
+		Hostnames: cfg.Security.CaptchaHostnames,
+		Action:    cfg.Security.CaptchaAction,
+		DevBypass: cfg.Security.CaptchaDevBypass,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Security.CaptchaDevBypass {
+		log.Printf("WARNING: captcha verification is bypassed (FAUCET_CAPTCHA_DEV_BYPASS), do not use in production")
+	}
+	if (cfg.Security.IPClaimCooldown > 0 || cfg.Security.IPDailyClaimLimit > 0) && len(cfg.Security.TrustedProxies) == 0 && len(cfg.Security.CloudflareProxies) == 0 {
+		log.Printf("WARNING: IP claim limits are on but no proxies are trusted (FAUCET_TRUSTED_PROXIES); behind a reverse proxy every client shares its IP and the limits apply to the whole faucet")
+	}
+
+	return &serverState{
+		config:  cfg,
+		assets:  buildAssets(cfg),
+		captcha: captcha,
+		cors: cors.New(cors.Options{
+			AllowedOrigins:   cfg.CORS.AllowedOrigins,
+			AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
+			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Idempotency-Key", "X-API-Key"},
+			ExposedHeaders:   []string{"Link", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Idempotent-Replayed"},
+			AllowCredentials: true,
+			MaxAge:           300, // Maximum value not readily apparent
+		}),
+		apiCors: cors.New(cors.Options{
+			AllowedOrigins:   cfg.CORS.AllowedOrigins,
+			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "Idempotency-Key", "X-API-Key"},
+			ExposedHeaders:   []string{"Link", "Retry-After", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Idempotent-Replayed"},
+			AllowCredentials: true,
+			MaxAge:           300,
+		}),
+	}, nil
+}
+
+// cfg returns the current configuration
+func (s *Server) cfg() *config.Config {
+	return s.state.Load().config
+}
+
+// corsHandler applies the CORS policy of the current state, picked by pick
+func (s *Server) corsHandler(pick func(*serverState) *cors.Cors) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			pick(s.state.Load()).Handler(next).ServeHTTP(w, r)
+		})
+	}
+}
+
+// EnableReload sets how Reload gets a fresh configuration, typically by
+// reading the same sources as at startup
+func (s *Server) EnableReload(load func() (*config.Config, error)) {
+	s.loadConfig = load
+}
+
+// Reload loads and validates the configuration again, then swaps in the
+// settings that can change without a restart. The running configuration is
+// kept if anything fails. It returns the settings that changed.
+func (s *Server) Reload() ([]string, error) {
+	if s.loadConfig == nil {
+		return nil, fmt.Errorf("reloading is not enabled")
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	next, err := s.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+
+	current := s.cfg()
+	merged := current.WithReloadable(next)
+	state, err := newServerState(merged)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure captcha: %w", err)
+	}
+
+	// The signing policy is derived from the amounts and tokens, so it has to
+	// follow them or new tokens and raised amounts would be refused at signing
+	policy, err := merged.SignerPolicy()
+	if err != nil {
+		return nil, err
+	}
+
+	changes := current.Diff(merged)
+	for _, change := range merged.Diff(next) {
+		log.Printf("[Reload] Ignoring %s, it requires a restart", change)
+	}
+	if len(changes) == 0 {
+		log.Printf("[Reload] Configuration reloaded, nothing changed")
+		return nil, nil
+	}
+
+	s.state.Store(state)
+	if s.signerPolicy != nil {
+		s.signerPolicy.Update(policy)
+	} else if before, err := current.SignerPolicy(); err == nil && !reflect.DeepEqual(before, policy) {
+		log.Printf("[Reload] The signing daemon keeps its own policy; restart it with the new amounts and tokens")
+	}
+	s.limiter.SetLimit(merged.Security.RateLimitRequests, time.Duration(merged.Security.RateLimitDuration)*time.Second)
+	s.statusLimiter.SetLimit(merged.Security.StatusRateLimit, time.Duration(merged.Security.RateLimitDuration)*time.Second)
+	s.readLimiter.SetLimit(merged.Security.ReadRateLimit, time.Duration(merged.Security.RateLimitDuration)*time.Second)
+	for _, change := range changes {
+		log.Printf("[Reload] %s", change)
+	}
+	log.Printf("[Reload] Configuration reloaded, %d setting%s changed", len(changes), pluralize(len(changes)))
+	return changes, nil
+}
+
+// handleReload reloads the configuration on behalf of an operator holding
+// the admin token
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	token := ""
+	if auth := r.Header.Get("Authorization"); len(auth) > 7 && auth[:7] == "Bearer " {
+		token = auth[7:]
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg().Security.AdminToken)) != 1 {
+		writeError(w, http.StatusUnauthorized, "Invalid admin token")
+		return
+	}
+
+	changes, err := s.Reload()
+	if err != nil {
+		log.Printf("[Reload] Keeping the running configuration: %v", err)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"success": false,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if changes == nil {
+		changes = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"changes": changes,
+	})
+}
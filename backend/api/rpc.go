@@ -0,0 +1,348 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/maestroi/solana-faucet/backend/models"
+	"github.com/maestroi/solana-faucet/backend/utils"
+)
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcErrParse          = -32700
+	rpcErrInvalidRequest = -32600
+	rpcErrMethodNotFound = -32601
+	rpcErrInvalidParams  = -32602
+	rpcErrInternal       = -32603
+	// rpcErrRateLimited matches the code public Solana RPC nodes return for airdrop limits
+	rpcErrRateLimited  = 429
+	rpcErrUnauthorized = 401
+)
+
+// rpcMaxBatchSize bounds the requests in one JSON-RPC batch. At most one of
+// them may be a requestAirdrop, since each waits for its claim to be sent.
+const rpcMaxBatchSize = 100
+
+// rpcProxiedMethods are read-only methods forwarded to the cluster because
+// `solana airdrop` calls them around requestAirdrop
+var rpcProxiedMethods = map[string]bool{
+	"getBalance":         true,
+	"getLatestBlockhash": true,
+}
+
+// rpcCaller is who a JSON-RPC request came from
+type rpcCaller struct {
+	ClientIP string
+	APIKey   *models.APIKey // nil for anonymous callers
+}
+
+// rpcRequest represents a JSON-RPC 2.0 request
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// rpcResponse represents a JSON-RPC 2.0 response
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError represents a JSON-RPC 2.0 error object
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// handleJSONRPC serves the subset of the Solana JSON-RPC API needed for
+// `solana airdrop` and connection.requestAirdrop to work against the faucet
+func (s *Server) handleJSONRPC(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeJSON(w, http.StatusOK, rpcErrorResponse(nil, rpcErrParse, "Parse error"))
+		return
+	}
+
+	caller := &rpcCaller{ClientIP: clientIPFromRequest(r)}
+
+	// API keys lift the anonymous limits, as on the REST endpoint
+	if key := apiKeyFromRequest(r); key != "" {
+		apiKey, err := s.authenticateAPIKey(key)
+		if err != nil {
+			log.Printf("[RPC] Error checking API key: %v", err)
+			writeJSON(w, http.StatusOK, rpcErrorResponse(nil, rpcErrInternal, "Internal error"))
+			return
+		}
+		if apiKey == nil {
+			writeJSON(w, http.StatusOK, rpcErrorResponse(nil, rpcErrUnauthorized, "Invalid API key"))
+			return
+		}
+		caller.APIKey = apiKey
+	}
+
+	// Batch request
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []rpcRequest
+		if err := json.Unmarshal(body, &batch); err != nil {
+			writeJSON(w, http.StatusOK, rpcErrorResponse(nil, rpcErrParse, "Parse error"))
+			return
+		}
+		if len(batch) == 0 || len(batch) > rpcMaxBatchSize {
+			writeJSON(w, http.StatusOK, rpcErrorResponse(nil, rpcErrInvalidRequest, "Invalid request"))
+			return
+		}
+
+		responses := make([]*rpcResponse, 0, len(batch))
+		airdrops := 0
+		for i := range batch {
+			req := &batch[i]
+
+			// The limiter counted the HTTP request once; charge the rest
+			if i > 0 && !s.readLimiter.Allow(r) {
+				responses = append(responses, rpcErrorResponse(req.ID, rpcErrRateLimited, "Too many requests, please try again later"))
+				continue
+			}
+			if req.Method == "requestAirdrop" {
+				airdrops++
+				if airdrops > 1 {
+					responses = append(responses, rpcErrorResponse(req.ID, rpcErrInvalidRequest, "At most one requestAirdrop per batch"))
+					continue
+				}
+			}
+			responses = append(responses, s.dispatchRPCFrom(r, req, caller))
+		}
+		writeJSON(w, http.StatusOK, responses)
+		return
+	}
+
+	// Single request
+	var req rpcRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSON(w, http.StatusOK, rpcErrorResponse(nil, rpcErrParse, "Parse error"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.dispatchRPCFrom(r, &req, caller))
+}
+
+// dispatchRPCFrom dispatches a request that arrived in r, charging airdrops
+// against the claim rate limit on top of the read-only one every JSON-RPC
+// request counts against
+func (s *Server) dispatchRPCFrom(r *http.Request, req *rpcRequest, caller *rpcCaller) *rpcResponse {
+	if req.Method == "requestAirdrop" && !s.limiter.Allow(r) {
+		return rpcErrorResponse(req.ID, rpcErrRateLimited, "Too many requests, please try again later")
+	}
+	return s.dispatchRPC(req, caller)
+}
+
+// dispatchRPC routes a single JSON-RPC request to its method implementation
+func (s *Server) dispatchRPC(req *rpcRequest, caller *rpcCaller) *rpcResponse {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcErrorResponse(req.ID, rpcErrInvalidRequest, "Invalid request")
+	}
+
+	log.Printf("[RPC] %s from %s", req.Method, caller.ClientIP)
+
+	var result interface{}
+	var rpcErr *rpcError
+	switch req.Method {
+	case "requestAirdrop":
+		result, rpcErr = s.rpcRequestAirdrop(req.Params, caller)
+	case "getSignatureStatuses":
+		result, rpcErr = s.rpcGetSignatureStatuses(req.Params)
+	default:
+		if !rpcProxiedMethods[req.Method] {
+			rpcErr = &rpcError{Code: rpcErrMethodNotFound, Message: "Method not found"}
+			break
+		}
+		raw, err := s.solana.ProxyRPC(req.Method, req.Params)
+		if err != nil {
+			rpcErr = &rpcError{Code: rpcErrInternal, Message: fmt.Sprintf("Failed to call %s", req.Method)}
+			break
+		}
+		result = raw
+	}
+
+	if rpcErr != nil {
+		return &rpcResponse{JSONRPC: "2.0", ID: req.ID, Error: rpcErr}
+	}
+	return &rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+}
+
+// rpcRequestAirdrop implements requestAirdrop(pubkey, lamports, config?)
+func (s *Server) rpcRequestAirdrop(params []json.RawMessage, caller *rpcCaller) (interface{}, *rpcError) {
+	// Airdrops have no captcha, so anonymous callers are only allowed when
+	// the per-IP limits stand in for it
+	cfg := s.cfg()
+	if caller.APIKey == nil && cfg.RPC.RequireAPIKey {
+		return nil, &rpcError{Code: rpcErrUnauthorized, Message: "requestAirdrop requires an API key"}
+	}
+	if caller.APIKey != nil {
+		if !models.Allows(caller.APIKey.Networks, cfg.Solana.NetworkType) {
+			return nil, &rpcError{Code: rpcErrUnauthorized, Message: "API key is not allowed on this network"}
+		}
+		if !models.Allows(caller.APIKey.Assets, nativeAsset) {
+			return nil, &rpcError{Code: rpcErrUnauthorized, Message: "API key is not allowed to claim SOL"}
+		}
+	}
+
+	if len(params) < 2 {
+		return nil, &rpcError{Code: rpcErrInvalidParams, Message: "Invalid params: expected pubkey and lamports"}
+	}
+
+	var walletAddress string
+	if err := json.Unmarshal(params[0], &walletAddress); err != nil || !utils.IsValidSolanaAddress(walletAddress) {
+		return nil, &rpcError{Code: rpcErrInvalidParams, Message: "Invalid params: invalid pubkey"}
+	}
+
+	var lamports uint64
+	if err := json.Unmarshal(params[1], &lamports); err != nil || lamports == 0 {
+		return nil, &rpcError{Code: rpcErrInvalidParams, Message: "Invalid params: invalid lamports"}
+	}
+
+	// Never pay out more than the REST endpoint would
+	maxAmount := cfg.Solana.AmountPerRequest
+	if caller.APIKey != nil && caller.APIKey.Amount > 0 {
+		maxAmount = caller.APIKey.Amount
+	}
+	maxLamports := uint64(maxAmount * 1e9)
+	if lamports > maxLamports {
+		return nil, &rpcError{
+			Code:    rpcErrInvalidParams,
+			Message: fmt.Sprintf("Invalid params: airdrop request exceeds faucet limit of %d lamports", maxLamports),
+		}
+	}
+
+	claimID, claimErr := s.enqueueClaim(&claimRequest{
+		WalletAddress: walletAddress,
+		ClientIP:      caller.ClientIP,
+		Asset:         s.findAsset(nativeAsset),
+		Amount:        float64(lamports) / 1e9,
+		APIKey:        caller.APIKey,
+	})
+	if claimErr != nil {
+		return nil, claimRPCError(claimErr)
+	}
+
+	// requestAirdrop returns a signature, so wait for a worker to send it
+	tx, err := s.queue.wait(claimID, time.Duration(cfg.Queue.WaitTimeout)*time.Second)
+	if err != nil {
+		log.Printf("[RPC] Error waiting for claim %d: %v", claimID, err)
+		return nil, &rpcError{Code: rpcErrInternal, Message: "Internal error"}
+	}
+	if tx == nil || tx.Status == "failed" {
+		return nil, &rpcError{Code: rpcErrInternal, Message: "Failed to send transaction"}
+	}
+	if tx.TxHash == "" {
+		return nil, &rpcError{
+			Code:    rpcErrInternal,
+			Message: "Airdrop is queued but has not been sent yet",
+			Data:    map[string]interface{}{"claimId": claimID},
+		}
+	}
+
+	return tx.TxHash, nil
+}
+
+// rpcGetSignatureStatuses implements getSignatureStatuses(signatures, config?)
+// for signatures sent by this faucet; unknown signatures report null
+func (s *Server) rpcGetSignatureStatuses(params []json.RawMessage) (interface{}, *rpcError) {
+	if len(params) < 1 {
+		return nil, &rpcError{Code: rpcErrInvalidParams, Message: "Invalid params: expected signatures"}
+	}
+
+	var signatures []string
+	if err := json.Unmarshal(params[0], &signatures); err != nil || len(signatures) == 0 {
+		return nil, &rpcError{Code: rpcErrInvalidParams, Message: "Invalid params: invalid signatures"}
+	}
+	if len(signatures) > 256 {
+		return nil, &rpcError{Code: rpcErrInvalidParams, Message: "Invalid params: too many signatures"}
+	}
+
+	var opts struct {
+		SearchTransactionHistory bool `json:"searchTransactionHistory"`
+	}
+	if len(params) > 1 {
+		if err := json.Unmarshal(params[1], &opts); err != nil {
+			return nil, &rpcError{Code: rpcErrInvalidParams, Message: "Invalid params: invalid config"}
+		}
+	}
+
+	// Only look up signatures this faucet produced
+	var known []string
+	for _, sig := range signatures {
+		tx, err := s.db.GetTransactionByHash(sig)
+		if err != nil {
+			log.Printf("[RPC] Error looking up transaction %s: %v", sig, err)
+			return nil, &rpcError{Code: rpcErrInternal, Message: "Internal error"}
+		}
+		if tx != nil {
+			known = append(known, sig)
+		}
+	}
+
+	result := &rpc.GetSignatureStatusesResult{
+		Value: make([]*rpc.SignatureStatusesResult, len(signatures)),
+	}
+	if len(known) == 0 {
+		return result, nil
+	}
+
+	statuses, err := s.solana.GetSignatureStatuses(known, opts.SearchTransactionHistory)
+	if err != nil {
+		return nil, &rpcError{Code: rpcErrInternal, Message: "Failed to get signature statuses"}
+	}
+	result.RPCContext = statuses.RPCContext
+
+	// Map the node's answers back onto the requested positions
+	bySig := make(map[string]*rpc.SignatureStatusesResult, len(known))
+	for i, sig := range known {
+		if i < len(statuses.Value) {
+			bySig[sig] = statuses.Value[i]
+		}
+	}
+	for i, sig := range signatures {
+		result.Value[i] = bySig[sig]
+	}
+
+	return result, nil
+}
+
+// claimRPCError converts a claim failure into a JSON-RPC error
+func claimRPCError(claimErr *claimError) *rpcError {
+	if !claimErr.NextClaimTime.IsZero() {
+		return &rpcError{
+			Code:    rpcErrRateLimited,
+			Message: claimErr.Message,
+			Data:    map[string]interface{}{"nextClaimTime": claimErr.NextClaimTime.Unix()},
+		}
+	}
+	if claimErr.Status == http.StatusTooManyRequests {
+		return &rpcError{Code: rpcErrRateLimited, Message: claimErr.Message}
+	}
+	if claimErr.Status == http.StatusBadRequest {
+		return &rpcError{Code: rpcErrInvalidParams, Message: claimErr.Message}
+	}
+	return &rpcError{Code: rpcErrInternal, Message: claimErr.Message}
+}
+
+// rpcErrorResponse builds a JSON-RPC error response
+func rpcErrorResponse(id json.RawMessage, code int, message string) *rpcResponse {
+	return &rpcResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   &rpcError{Code: code, Message: message},
+	}
+}
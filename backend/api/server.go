@@ -7,24 +7,53 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/maestroi/solana-faucet/backend/auth"
 	"github.com/maestroi/solana-faucet/backend/config"
 	"github.com/maestroi/solana-faucet/backend/db"
+	"github.com/maestroi/solana-faucet/backend/pow"
+	"github.com/maestroi/solana-faucet/backend/ratelimit"
+	"github.com/maestroi/solana-faucet/backend/signer"
 	"github.com/maestroi/solana-faucet/backend/utils"
+	"github.com/maestroi/solana-faucet/backend/wallet"
 )
 
 // Server represents the API server
 type Server struct {
-	config    *config.Config
 	db        *db.Database
 	router    *chi.Mux
 	solana    *utils.SolanaClient
 	server    *http.Server
-	turnstile *utils.TurnstileClient
+	pow       *pow.Issuer    // nil unless proof-of-work is enabled
+	sessions  *auth.Sessions // nil unless sign-in is enabled
+	limiter   *ratelimit.Limiter
+	confirmer *confirmer
+	queue     *claimQueue
+
+	// Claim status is polled while a claim is sent, so it has its own limit,
+	// and read-only requests get a looser one than claims
+	statusLimiter *ratelimit.Limiter
+	readLimiter   *ratelimit.Limiter
+
+	// Spending policy of the in-process signer, updated on reload; nil when
+	// signing through the daemon
+	signerPolicy *signer.Policy
+
+	// Configuration and what is built from it, swapped on reload
+	state      atomic.Pointer[serverState]
+	reloadMu   sync.Mutex // serializes reloads
+	loadConfig func() (*config.Config, error)
+
+	// Sign-in providers by name
+	authProviders map[string]auth.Provider
+
+	// Client IP resolution
+	ipResolver *ipResolver
 
 	// Balance caching
 	balanceMutex    sync.RWMutex
@@ -36,86 +65,294 @@ type Server struct {
 func NewServer(cfg *config.Config, database *db.Database) *Server {
 	r := chi.NewRouter()
 
+	// Resolve the client IP behind trusted proxies
+	resolver, err := newIPResolver(cfg.Security.TrustedProxies, cfg.Security.CloudflareProxies, cfg.Security.ClientIPHeaders)
+	if err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
+
 	// Set up middleware
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.Timeout(30 * time.Second))
 
-	// Set up CORS
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   cfg.CORS.AllowedOrigins,
-		AllowedMethods:   []string{"GET", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300, // Maximum value not readily apparent
-	}))
+	// Sign through the signing daemon, or with the wallet loaded in process
+	walletSigner, signerPolicy, err := newWalletSigner(cfg)
+	if err != nil {
+		log.Fatalf("Failed to set up signer: %v", err)
+	}
 
 	// Create Solana client
-	solanaClient, err := utils.NewSolanaClient(cfg.Solana.RpcURL, cfg.Solana.FaucetWalletPath)
+	var endpoints []utils.RPCEndpoint
+	for _, e := range cfg.Endpoints() {
+		endpoints = append(endpoints, utils.RPCEndpoint{URL: e.URL, Weight: e.Weight})
+	}
+	solanaClient, err := utils.NewSolanaClient(utils.ClientOptions{
+		Endpoints:           endpoints,
+		RPCTimeout:          time.Duration(cfg.Solana.RpcTimeout) * time.Second,
+		HealthCheckInterval: time.Duration(cfg.Solana.HealthCheckInterval) * time.Second,
+		Retry: utils.RetryPolicy{
+			MaxAttempts:         cfg.Solana.SendMaxAttempts,
+			RebroadcastInterval: time.Duration(cfg.Solana.RebroadcastInterval) * time.Millisecond,
+			Backoff:             time.Duration(cfg.Solana.RetryBackoff) * time.Millisecond,
+			StatusTimeout:       time.Duration(cfg.Solana.TransactionTimeout) * time.Second,
+		},
+		Fees: utils.FeePolicy{
+			ComputeUnitPrice: cfg.Solana.ComputeUnitPrice,
+			Dynamic:          cfg.Solana.DynamicPriorityFee,
+			Percentile:       cfg.Solana.PriorityFeePercentile,
+			MaxPriorityFee:   cfg.Solana.MaxPriorityFee,
+			ComputeUnitLimit: cfg.Solana.ComputeUnitLimit,
+		},
+	}, walletSigner)
 	if err != nil {
 		log.Fatalf("Failed to create Solana client: %v", err)
 	}
 
-	// Create Turnstile client
-	turnstileClient := utils.NewTurnstileClient(cfg.Security.TurnstileSecretKey)
+	// Build the captcha verifier, assets and CORS policy
+	state, err := newServerState(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure captcha: %v", err)
+	}
+
+	// Create proof-of-work issuer
+	var powIssuer *pow.Issuer
+	if cfg.Security.PowEnabled {
+		powIssuer, err = pow.NewIssuer([]byte(cfg.Security.PowSecret), time.Duration(cfg.Security.PowChallengeTTL)*time.Second)
+		if err != nil {
+			log.Fatalf("Failed to create proof-of-work issuer: %v", err)
+		}
+	}
+
+	// Set up sign-in
+	var sessions *auth.Sessions
+	authProviders := map[string]auth.Provider{}
+	if cfg.Auth.Enabled {
+		sessions, err = auth.NewSessions([]byte(cfg.Auth.SessionSecret), time.Duration(cfg.Auth.SessionTTL)*time.Second)
+		if err != nil {
+			log.Fatalf("Failed to create session signer: %v", err)
+		}
+		github, err := auth.NewGitHubProvider(auth.GitHubOptions{
+			ClientID:     cfg.Auth.GitHubClientID,
+			ClientSecret: cfg.Auth.GitHubClientSecret,
+			AuthorizeURL: cfg.Auth.GitHubAuthorizeURL,
+			TokenURL:     cfg.Auth.GitHubTokenURL,
+			UserURL:      cfg.Auth.GitHubUserURL,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure GitHub sign-in: %v", err)
+		}
+		authProviders[github.Name()] = github
+	}
+
+	// Create rate limiter
+	limiter := ratelimit.NewLimiter(
+		ratelimit.NewMemoryStore(),
+		cfg.Security.RateLimitRequests,
+		time.Duration(cfg.Security.RateLimitDuration)*time.Second,
+		clientIPFromRequest,
+	)
+	statusLimiter := ratelimit.NewLimiter(
+		ratelimit.NewMemoryStore(),
+		cfg.Security.StatusRateLimit,
+		time.Duration(cfg.Security.RateLimitDuration)*time.Second,
+		clientIPFromRequest,
+	)
+	readLimiter := ratelimit.NewLimiter(
+		ratelimit.NewMemoryStore(),
+		cfg.Security.ReadRateLimit,
+		time.Duration(cfg.Security.RateLimitDuration)*time.Second,
+		clientIPFromRequest,
+	)
 
 	// Create server
 	s := &Server{
-		config:    cfg,
 		db:        database,
 		router:    r,
 		solana:    solanaClient,
-		turnstile: turnstileClient,
+		pow:       powIssuer,
+		sessions:  sessions,
+		limiter:   limiter,
+		confirmer: newConfirmer(database, solanaClient, cfg.Solana.Commitment, time.Duration(cfg.Solana.ConfirmInterval)*time.Second),
+
+		statusLimiter: statusLimiter,
+		readLimiter:   readLimiter,
+		signerPolicy:  signerPolicy,
+		authProviders: authProviders,
+		ipResolver:    resolver,
 		server: &http.Server{
 			Addr:    fmt.Sprintf("%s:%d", cfg.Server.Address, cfg.Server.Port),
 			Handler: r,
 		},
 	}
 
+	s.state.Store(state)
+
+	// Set up CORS
+	r.Use(s.corsHandler(func(st *serverState) *cors.Cors { return st.cors }))
+
+	// Send claims on a worker pool rather than in the request handlers
+	s.queue = newClaimQueue(database, cfg.Queue.Workers, cfg.Queue.BatchSize, time.Duration(cfg.Queue.PollInterval)*time.Millisecond, s.executeClaims)
+
+	// Resolve the client IP before any handler or limiter needs it
+	r.Use(s.clientIPMiddleware)
+
 	// Set up routes
 	s.setupRoutes()
 
 	return s
 }
 
+// newWalletSigner connects to the signing daemon when one is configured,
+// so this process never holds the key, and otherwise loads the wallet and
+// enforces the spending policy in process. The policy is returned so reloads
+// can update it, and is nil with the daemon.
+func newWalletSigner(cfg *config.Config) (signer.Signer, *signer.Policy, error) {
+	if cfg.Signer.URL != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Signer.Timeout)*time.Second)
+		defer cancel()
+		remote, err := signer.NewRemote(ctx, signer.RemoteOptions{
+			URL:     cfg.Signer.URL,
+			Token:   cfg.Signer.Token,
+			Timeout: time.Duration(cfg.Signer.Timeout) * time.Second,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		log.Printf("Signing as %s through %s", remote.PublicKey(), cfg.Signer.URL)
+		return remote, nil, nil
+	}
+
+	opts := cfg.WalletOptions()
+	key, err := wallet.Load(opts)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load wallet from %s: %w", opts.Source(), err)
+	}
+	policyOpts, err := cfg.SignerPolicy()
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Printf("Loaded wallet %s from %s", key.PublicKey(), opts.Source())
+	policy := signer.NewPolicy(policyOpts)
+	return signer.WithPolicy(signer.NewLocal(key), policy), policy, nil
+}
+
 // setupRoutes sets up the API routes
 func (s *Server) setupRoutes() {
+	cfg := s.cfg()
 	s.router.Group(func(r chi.Router) {
 		// Apply CORS middleware
-		r.Use(cors.Handler(cors.Options{
-			AllowedOrigins:   s.config.CORS.AllowedOrigins,
-			AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-			AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type"},
-			ExposedHeaders:   []string{"Link"},
-			AllowCredentials: true,
-			MaxAge:           300,
-		}))
+		r.Use(s.corsHandler(func(st *serverState) *cors.Cors { return st.apiCors }))
 
 		// Health check
 		r.Get("/api/health", s.handleHealth)
 
-		// Request funds
-		r.Post("/api/request-funds", s.handleRequestFunds)
+		// Claim status, polled by clients until a claim is confirmed
+		r.With(s.statusLimiter.Handler).Get("/api/claims/{id}", s.handleGetClaim)
+
+		// Claims and admin requests are strictly rate limited per client IP
+		// and route
+		r.Group(func(r chi.Router) {
+			r.Use(s.limiter.Handler)
+
+			// Request funds
+			r.Post("/api/request-funds", s.handleRequestFunds)
+
+			// Reload the configuration
+			if cfg.Security.AdminToken != "" {
+				r.Post("/api/admin/reload", s.handleReload)
+			}
+		})
+
+		// Everything else only reads and is refreshed by the frontend, so it
+		// has a looser limit
+		r.Group(func(r chi.Router) {
+			r.Use(s.readLimiter.Handler)
+
+			// Proof-of-work challenge
+			r.Get("/api/pow-challenge", s.handlePowChallenge)
+
+			// Sign-in
+			r.Get("/api/auth/session", s.handleAuthSession)
+			r.Post("/api/auth/logout", s.handleAuthLogout)
+			if s.sessions != nil {
+				r.Get("/api/auth/{provider}/login", s.handleAuthLogin)
+				r.Get("/api/auth/{provider}/callback", s.handleAuthCallback)
+			}
 
-		// Get transactions
-		r.Get("/api/transactions", s.handleGetTransactions)
+			// Get transactions
+			r.Get("/api/transactions", s.handleGetTransactions)
 
-		// Get balance
-		r.Get("/api/balance", s.handleGetBalance)
+			// Get balance
+			r.Get("/api/balance", s.handleGetBalance)
+
+			// List claimable assets
+			r.Get("/api/assets", s.handleGetAssets)
+
+			// RPC endpoint health
+			r.Get("/api/rpc-status", s.handleRPCStatus)
+
+			// Solana JSON-RPC compatible airdrop endpoint; requestAirdrop is
+			// charged against the claim limit by the handler
+			if cfg.RPC.Enabled {
+				r.Post("/", s.handleJSONRPC)
+				r.Post("/api/rpc", s.handleJSONRPC)
+			}
+		})
 	})
 }
 
-// Start starts the API server
+// Start starts the API server, the claim workers and the background
+// transaction confirmer
 func (s *Server) Start() error {
+	// Sends interrupted by a previous shutdown after signing may have landed;
+	// hand them to the confirmer to settle by their signature
+	if n, err := s.db.ResumeSignedTransactions(); err != nil {
+		log.Printf("[Server] Error resuming signed transactions: %v", err)
+	} else if n > 0 {
+		log.Printf("[Server] Resumed %d interrupted transactions for confirmation", n)
+	}
+
+	// The rest were interrupted before signing and never reached the cluster
+	if n, err := s.db.FailUnsentTransactions("interrupted before the transaction was sent"); err != nil {
+		log.Printf("[Server] Error failing unsent transactions: %v", err)
+	} else if n > 0 {
+		log.Printf("[Server] Marked %d unsent transactions as failed", n)
+	}
+
+	s.queue.start()
+	go s.confirmer.run()
 	return s.server.ListenAndServe()
 }
 
-// Shutdown gracefully shuts down the API server
+// shutdownTimeout bounds Shutdown, leaving headroom under docker's default
+// 10 second stop grace period
+const shutdownTimeout = 8 * time.Second
+
+// Shutdown gracefully shuts down the API server. HTTP requests and in-flight
+// claim sends share one deadline.
 func (s *Server) Shutdown() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
-	return s.server.Shutdown(ctx)
+	err := s.server.Shutdown(ctx)
+	s.queue.stop(ctx)
+	s.confirmer.stop()
+	s.solana.Close()
+	return err
+}
+
+// handleRPCStatus reports the health of each configured Solana RPC endpoint
+func (s *Server) handleRPCStatus(w http.ResponseWriter, r *http.Request) {
+	response := struct {
+		Success   bool                   `json:"success"`
+		Endpoints []utils.EndpointHealth `json:"endpoints"`
+	}{
+		Success:   true,
+		Endpoints: s.solana.RPCHealth(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
 
 // handleHealth handles the health check endpoint
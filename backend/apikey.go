@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/maestroi/solana-faucet/backend/db"
+	"github.com/maestroi/solana-faucet/backend/models"
+	"github.com/maestroi/solana-faucet/backend/utils"
+)
+
+// apiKeyUsage describes the apikey subcommand
+const apiKeyUsage = `Usage:
+  faucet apikey create -name NAME [-quota N] [-amount SOL] [-networks a,b] [-assets A,B]
+  faucet apikey list
+  faucet apikey revoke ID`
+
+// runAPIKeyCommand manages API keys from the command line and returns the
+// process exit code
+func runAPIKeyCommand(database *db.Database, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, apiKeyUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "create":
+		return createAPIKey(database, args[1:])
+	case "list":
+		return listAPIKeys(database)
+	case "revoke":
+		return revokeAPIKey(database, args[1:])
+	default:
+		fmt.Fprintln(os.Stderr, apiKeyUsage)
+		return 2
+	}
+}
+
+// createAPIKey issues a new key and prints it once
+func createAPIKey(database *db.Database, args []string) int {
+	fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+	name := fs.String("name", "", "Who the key is for")
+	quota := fs.Int("quota", 100, "Claims per 24 hours, 0 for unlimited")
+	amount := fs.Float64("amount", 0, "SOL per claim, 0 for the faucet default")
+	networks := fs.String("networks", "", "Comma-separated networks the key may be used on, all when empty")
+	assets := fs.String("assets", "", "Comma-separated asset symbols the key may claim, all when empty")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "apikey create: -name is required")
+		return 2
+	}
+
+	key, prefix, err := utils.GenerateAPIKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate API key: %v\n", err)
+		return 1
+	}
+
+	record := &models.APIKey{
+		Name:       *name,
+		Prefix:     prefix,
+		DailyQuota: *quota,
+		Amount:     *amount,
+		Networks:   splitList(*networks),
+		Assets:     splitList(strings.ToUpper(*assets)),
+	}
+	id, err := database.CreateAPIKey(record, utils.HashAPIKey(key))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to store API key: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Created API key %d for %s:\n\n  %s\n\nStore it now, it can't be shown again.\n", id, *name, key)
+	return 0
+}
+
+// listAPIKeys prints every key without revealing it
+func listAPIKeys(database *db.Database) int {
+	keys, err := database.ListAPIKeys()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list API keys: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tPREFIX\tQUOTA\tAMOUNT\tNETWORKS\tASSETS\tCREATED\tLAST USED\tSTATUS")
+	for _, k := range keys {
+		lastUsed := "never"
+		if k.LastUsedAt != nil {
+			lastUsed = k.LastUsedAt.Format("2006-01-02 15:04")
+		}
+		status := "active"
+		if k.RevokedAt != nil {
+			status = "revoked " + k.RevokedAt.Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%g\t%s\t%s\t%s\t%s\t%s\n",
+			k.ID, k.Name, k.Prefix, k.DailyQuota, k.Amount,
+			listOrAll(k.Networks), listOrAll(k.Assets),
+			k.CreatedAt.Format("2006-01-02 15:04"), lastUsed, status)
+	}
+	w.Flush()
+	return 0
+}
+
+// revokeAPIKey revokes a key by ID
+func revokeAPIKey(database *db.Database, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, apiKeyUsage)
+		return 2
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid API key ID %q\n", args[0])
+		return 2
+	}
+
+	revoked, err := database.RevokeAPIKey(id)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to revoke API key: %v\n", err)
+		return 1
+	}
+	if !revoked {
+		fmt.Fprintf(os.Stderr, "No active API key with ID %d\n", id)
+		return 1
+	}
+
+	fmt.Printf("Revoked API key %d\n", id)
+	return 0
+}
+
+// splitList parses a comma-separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// listOrAll renders an allow list for display
+func listOrAll(items []string) string {
+	if len(items) == 0 {
+		return "all"
+	}
+	return strings.Join(items, ",")
+}
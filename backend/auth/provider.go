@@ -0,0 +1,33 @@
+// Package auth implements OAuth sign-in with third-party identity providers
+// and the signed session cookies issued afterwards.
+package auth
+
+import (
+	"context"
+	"time"
+)
+
+// Identity is a user as reported by an identity provider
+type Identity struct {
+	Provider  string    `json:"p"`
+	Subject   string    `json:"sub"` // stable account ID at the provider
+	Login     string    `json:"login"`
+	CreatedAt time.Time `json:"created"` // when the account was created
+}
+
+// Key identifies the user across providers, e.g. "github:1234"
+func (i *Identity) Key() string {
+	return i.Provider + ":" + i.Subject
+}
+
+// Provider is an OAuth 2.0 identity provider
+type Provider interface {
+	// Name is the provider's identifier in URLs and identity keys
+	Name() string
+
+	// AuthCodeURL returns the URL that starts the authorization flow
+	AuthCodeURL(state, redirectURL string) string
+
+	// Exchange trades an authorization code for the signed-in user's identity
+	Exchange(ctx context.Context, code, redirectURL string) (*Identity, error)
+}
@@ -2,9 +2,15 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/maestroi/solana-faucet/backend/signer"
+	"github.com/maestroi/solana-faucet/backend/utils"
+	"github.com/maestroi/solana-faucet/backend/wallet"
 )
 
 // Config represents the application configuration
@@ -17,117 +23,515 @@ type Config struct {
 		Path string
 	}
 	Solana struct {
-		RpcURL             string
-		FaucetWalletPath   string
-		AmountPerRequest   float64
-		NetworkType        string // "testnet", "devnet", etc.
-		TransactionTimeout int
+		RpcURL                   string
+		RpcEndpoints             []RPCEndpoint // weighted endpoints; RpcURL is used when empty
+		RpcTimeout               int           // in seconds, per RPC call before failing over
+		HealthCheckInterval      int           // in seconds, 0 disables background endpoint probes
+		FaucetWalletPath         string        // keypair JSON or encrypted keystore file
+		WalletSecretKey          string        // base58 keypair, used instead of the file when set
+		WalletMnemonic           string        // BIP39 mnemonic, used instead of the file when set
+		WalletMnemonicPassphrase string        // optional BIP39 passphrase for the mnemonic
+		WalletDerivationPath     string        // derivation path for the mnemonic
+		WalletPassphrase         string        // unlocks an encrypted keystore file
+		WalletPassphraseFile     string        // file holding the keystore passphrase
+		AmountPerRequest         float64
+		NetworkType              string // "testnet", "devnet", etc.
+		TransactionTimeout       int    // in seconds a send may go without a block height before its outcome is unknown
+		Commitment               string // commitment a transfer must reach to count as completed
+		ConfirmInterval          int    // in seconds, how often pending transfers are polled
+		SendMaxAttempts          int    // blockhashes a transfer is signed with before giving up
+		RebroadcastInterval      int    // in milliseconds, between resends of an unseen transaction
+		RetryBackoff             int    // in milliseconds before re-signing, doubled each attempt
+		ComputeUnitPrice         uint64 // fixed priority fee in micro-lamports per compute unit
+		DynamicPriorityFee       bool   // derive the price from getRecentPrioritizationFees
+		PriorityFeePercentile    int    // percentile of recent fees used when dynamic
+		MaxPriorityFee           uint64 // in lamports per transaction, 0 for no cap
+		ComputeUnitLimit         uint32 // overrides the per-transfer limit when set
 	}
 	Security struct {
-		TurnstileSecretKey string
-		TurnstileSiteKey   string
+		CaptchaProvider    string // "turnstile", "hcaptcha", "recaptcha", "recaptcha-v3" or "none"
+		CaptchaSecretKey   string
+		CaptchaSiteKey     string
+		CaptchaVerifyURL   string   // overrides the provider's siteverify URL
+		CaptchaMinScore    float64  // lowest reCAPTCHA v3 score accepted
+		CaptchaHostnames   []string // hostnames tokens may be solved on, any when empty
+		CaptchaAction      string   // action tokens must carry, any when empty
+		CaptchaDevBypass   bool     // accept every token without verifying, for local development only
 		RateLimitRequests  int
-		RateLimitDuration  int // in seconds
-		ClaimCooldown      int // in seconds
+		RateLimitDuration  int      // in seconds
+		StatusRateLimit    int      // claim status polls per client IP per RateLimitDuration, 0 disables
+		ReadRateLimit      int      // read-only requests per client IP and route per RateLimitDuration, 0 disables
+		ClaimCooldown      int      // in seconds
+		IPClaimCooldown    int      // in seconds, per client IP/subnet, 0 disables
+		IPDailyClaimLimit  int      // claims per client IP/subnet per 24 hours, 0 disables
+		IPv4SubnetPrefix   int      // IPv4 prefix length claims are aggregated by, 32 for single addresses
+		IPv6SubnetPrefix   int      // IPv6 prefix length claims are aggregated by, 128 for single addresses
+		TrustedProxies     []string // CIDRs whose forwarding headers are believed
+		CloudflareProxies  []string // CIDRs whose CF-Connecting-IP header is believed
+		ClientIPHeaders    []string // headers consulted in order for the client IP
+		IdempotencyWindow  int      // in seconds a repeated Idempotency-Key replays the original claim
+		PowEnabled         bool     // accept proof-of-work solutions in place of a captcha
+		PowSecret          string   // HMAC key for challenges, random per process when empty
+		PowDifficulty      int      // leading zero bits required at normal load
+		PowMaxDifficulty   int      // cap on the scaled difficulty
+		PowChallengeTTL    int      // in seconds a challenge stays valid
+		PowVolumeThreshold int      // claims per hour above which the difficulty rises
+		AdminToken         string   // bearer token for the admin API, which is off when empty
 	}
 	CORS struct {
 		AllowedOrigins []string
 	}
+	RPC struct {
+		Enabled       bool // serve the Solana JSON-RPC requestAirdrop endpoint
+		RequireAPIKey bool // only allow requestAirdrop with an API key
+	}
+	Queue struct {
+		Workers      int // claims sent concurrently
+		BatchSize    int // SOL claims packed into one transaction, at most 20
+		PollInterval int // in milliseconds, how often idle workers check for new claims
+		WaitTimeout  int // in seconds a JSON-RPC airdrop waits for its claim to be sent
+	}
+	Auth struct {
+		Enabled            bool   // offer OAuth sign-in for higher allowances
+		SessionSecret      string // HMAC key for session cookies, random per process when empty
+		SessionTTL         int    // in seconds a session lasts
+		CookieSecure       bool   // only send the session cookie over HTTPS
+		PublicURL          string // URL the API is reached at, for OAuth callbacks
+		RedirectURL        string // frontend URL users return to after signing in
+		GitHubClientID     string
+		GitHubClientSecret string
+		GitHubAuthorizeURL string // overrides GitHub's OAuth endpoints, e.g. for a mock provider
+		GitHubTokenURL     string
+		GitHubUserURL      string
+		MinAccountAge      int     // in days a GitHub account must exist before it can sign in
+		Amount             float64 // SOL per claim for signed-in users, 0 for the base amount
+		DailyClaims        int     // claims per identity and asset per 24 hours, 0 for no cap
+		Cooldown           int     // in seconds between claims of an identity
+	}
+	Signer struct {
+		URL                string   // signing daemon to sign through, the wallet is loaded in process when empty
+		Token              string   // bearer token shared with the signing daemon
+		Timeout            int      // in seconds per request to the signing daemon
+		Listen             string   // address `faucet signer serve` listens on
+		MaxLamportsPerTx   uint64   // lamports one transaction may spend, derived from the claim amounts when 0
+		MaxLamportsPerHour uint64   // lamports all transactions may spend per rolling hour, derived when 0
+		AllowedPrograms    []string // program IDs transactions may invoke, the faucet's own when empty
+	}
+	Tokens []TokenConfig // SPL tokens offered in addition to SOL
+
+	// envErrors are the environment variables LoadConfig could not parse
+	envErrors []error
+}
+
+// RPCEndpoint is a Solana RPC URL and its relative share of the traffic
+type RPCEndpoint struct {
+	URL    string
+	Weight int
+}
+
+// TokenConfig describes an SPL token the faucet hands out
+type TokenConfig struct {
+	Symbol   string
+	Mint     string
+	Program  string  // "spl-token" (default) or "token-2022"
+	Amount   float64 // in whole tokens received per claim, after any transfer fee
+	Cooldown int     // in seconds
+	MaxPerTx float64 // whole tokens one transaction may send including any fee, twice Amount when 0
+}
+
+// WalletOptions says where the faucet's keypair is loaded from
+func (c *Config) WalletOptions() wallet.Options {
+	return wallet.Options{
+		Path:               c.Solana.FaucetWalletPath,
+		SecretKey:          c.Solana.WalletSecretKey,
+		Mnemonic:           c.Solana.WalletMnemonic,
+		MnemonicPassphrase: c.Solana.WalletMnemonicPassphrase,
+		DerivationPath:     c.Solana.WalletDerivationPath,
+		Passphrase:         c.Solana.WalletPassphrase,
+		PassphraseFile:     c.Solana.WalletPassphraseFile,
+	}
 }
 
-// LoadConfig loads the application configuration from environment variables
-func LoadConfig(_ string) (*Config, error) {
-	var config Config
+// Spending limits used when the signer's aren't set: a full batch of the
+// largest claim plus fees per transaction, and that many batches per hour
+const (
+	defaultSignerBatchesPerHour = 10
+	defaultSignerFeeAllowance   = 1_000_000 // lamports of priority fee when it isn't capped
+)
+
+// SignerPolicy returns the spending policy the signer enforces. Limits that
+// aren't set are derived from the claim amounts, and each token may send
+// its MaxPerTx per transaction.
+func (c *Config) SignerPolicy() (signer.PolicyOptions, error) {
+	opts := signer.PolicyOptions{
+		MaxLamportsPerTx:   c.Signer.MaxLamportsPerTx,
+		MaxLamportsPerHour: c.Signer.MaxLamportsPerHour,
+		MaxTokensPerTx:     make(map[solana.PublicKey]float64, len(c.Tokens)),
+	}
+	if opts.MaxLamportsPerTx == 0 || opts.MaxLamportsPerHour == 0 {
+		amount := c.Solana.AmountPerRequest
+		if c.Auth.Amount > amount {
+			amount = c.Auth.Amount
+		}
+		fees := c.Solana.MaxPriorityFee
+		if fees == 0 {
+			fees = defaultSignerFeeAllowance
+		}
+		// Claim amounts, the signature fee and the priority fee
+		batch := uint64(amount*1e9)*utils.MaxSOLBatchSize + 5000 + fees
+		if opts.MaxLamportsPerTx == 0 {
+			opts.MaxLamportsPerTx = batch
+		}
+		if opts.MaxLamportsPerHour == 0 {
+			opts.MaxLamportsPerHour = batch * defaultSignerBatchesPerHour
+		}
+	}
+	for _, t := range c.Tokens {
+		mint, err := solana.PublicKeyFromBase58(t.Mint)
+		if err != nil {
+			// Reported by Validate with the token's other problems
+			continue
+		}
+		max := t.MaxPerTx
+		if max == 0 {
+			max = 2 * t.Amount
+		}
+		opts.MaxTokensPerTx[mint] = max
+	}
+	for _, id := range c.Signer.AllowedPrograms {
+		program, err := solana.PublicKeyFromBase58(id)
+		if err != nil {
+			return opts, fmt.Errorf("invalid program ID %q", id)
+		}
+		opts.AllowedPrograms = append(opts.AllowedPrograms, program)
+	}
+	return opts, nil
+}
+
+// LoadConfig loads the application configuration in layers: the built-in
+// defaults, then the config file at path if one is given, then environment
+// variables. Call Validate on the result before using it.
+func LoadConfig(path string) (*Config, error) {
+	config := DefaultConfig()
+	env := &envReader{}
+	if path != "" {
+		if err := loadFile(path, config); err != nil {
+			return nil, err
+		}
+	}
 
 	// Server config
-	config.Server.Address = getEnvWithDefault("FAUCET_SERVER_ADDRESS", "0.0.0.0")
-	config.Server.Port = getEnvIntWithDefault("FAUCET_SERVER_PORT", 8080)
+	config.Server.Address = env.getWithDefault("FAUCET_SERVER_ADDRESS", config.Server.Address)
+	config.Server.Port = env.getIntWithDefault("FAUCET_SERVER_PORT", config.Server.Port)
 
 	// Database config
-	config.Database.Path = getEnvWithDefault("FAUCET_DB_PATH", "/app/data/faucet.db")
+	config.Database.Path = env.getWithDefault("FAUCET_DB_PATH", config.Database.Path)
 
 	// Solana config
-	config.Solana.RpcURL = getEnvWithDefault("FAUCET_SOLANA_RPC_URL", "https://api.testnet.solana.com")
-	config.Solana.FaucetWalletPath = getEnvWithDefault("FAUCET_WALLET_PATH", "/app/data/wallet.json")
-	config.Solana.AmountPerRequest = getEnvFloatWithDefault("FAUCET_AMOUNT_PER_REQUEST", 1.0)
-	config.Solana.NetworkType = getEnvWithDefault("FAUCET_NETWORK_TYPE", "testnet")
-	config.Solana.TransactionTimeout = getEnvIntWithDefault("FAUCET_TRANSACTION_TIMEOUT", 30)
+	config.Solana.RpcURL = env.getWithDefault("FAUCET_SOLANA_RPC_URL", config.Solana.RpcURL)
+	if value := os.Getenv("FAUCET_SOLANA_RPC_URLS"); value != "" {
+		endpoints, err := parseRPCEndpoints(value)
+		if err != nil {
+			env.errs = append(env.errs, fmt.Errorf("FAUCET_SOLANA_RPC_URLS: %w", err))
+		}
+		config.Solana.RpcEndpoints = endpoints
+	}
+	config.Solana.RpcTimeout = env.getIntWithDefault("FAUCET_SOLANA_RPC_TIMEOUT", config.Solana.RpcTimeout)
+	config.Solana.HealthCheckInterval = env.getIntWithDefault("FAUCET_SOLANA_HEALTH_CHECK_INTERVAL", config.Solana.HealthCheckInterval)
+	config.Solana.FaucetWalletPath = env.getWithDefault("FAUCET_WALLET_PATH", config.Solana.FaucetWalletPath)
+	config.Solana.WalletSecretKey = env.getWithDefault("FAUCET_WALLET_SECRET_KEY", config.Solana.WalletSecretKey)
+	config.Solana.WalletMnemonic = env.getWithDefault("FAUCET_WALLET_MNEMONIC", config.Solana.WalletMnemonic)
+	config.Solana.WalletMnemonicPassphrase = env.getWithDefault("FAUCET_WALLET_MNEMONIC_PASSPHRASE", config.Solana.WalletMnemonicPassphrase)
+	config.Solana.WalletDerivationPath = env.getWithDefault("FAUCET_WALLET_DERIVATION_PATH", config.Solana.WalletDerivationPath)
+	config.Solana.WalletPassphrase = env.getWithDefault("FAUCET_WALLET_PASSPHRASE", config.Solana.WalletPassphrase)
+	config.Solana.WalletPassphraseFile = env.getWithDefault("FAUCET_WALLET_PASSPHRASE_FILE", config.Solana.WalletPassphraseFile)
+	config.Solana.AmountPerRequest = env.getFloatWithDefault("FAUCET_AMOUNT_PER_REQUEST", config.Solana.AmountPerRequest)
+	config.Solana.NetworkType = env.getWithDefault("FAUCET_NETWORK_TYPE", config.Solana.NetworkType)
+	config.Solana.TransactionTimeout = env.getIntWithDefault("FAUCET_TRANSACTION_TIMEOUT", config.Solana.TransactionTimeout)
+	config.Solana.SendMaxAttempts = env.getIntWithDefault("FAUCET_SEND_MAX_ATTEMPTS", config.Solana.SendMaxAttempts)
+	config.Solana.RebroadcastInterval = env.getIntWithDefault("FAUCET_REBROADCAST_INTERVAL_MS", config.Solana.RebroadcastInterval)
+	config.Solana.RetryBackoff = env.getIntWithDefault("FAUCET_RETRY_BACKOFF_MS", config.Solana.RetryBackoff)
+	config.Solana.ComputeUnitPrice = env.getUintWithDefault("FAUCET_COMPUTE_UNIT_PRICE", config.Solana.ComputeUnitPrice, 64)
+	config.Solana.DynamicPriorityFee = env.getBoolWithDefault("FAUCET_DYNAMIC_PRIORITY_FEE", config.Solana.DynamicPriorityFee)
+	config.Solana.PriorityFeePercentile = env.getIntWithDefault("FAUCET_PRIORITY_FEE_PERCENTILE", config.Solana.PriorityFeePercentile)
+	config.Solana.MaxPriorityFee = env.getUintWithDefault("FAUCET_MAX_PRIORITY_FEE", config.Solana.MaxPriorityFee, 64)
+	config.Solana.ComputeUnitLimit = uint32(env.getUintWithDefault("FAUCET_COMPUTE_UNIT_LIMIT", uint64(config.Solana.ComputeUnitLimit), 32))
+	config.Solana.Commitment = env.getWithDefault("FAUCET_SOLANA_COMMITMENT", config.Solana.Commitment)
+	config.Solana.ConfirmInterval = env.getIntWithDefault("FAUCET_CONFIRM_INTERVAL", config.Solana.ConfirmInterval)
 
 	// Security config
-	config.Security.TurnstileSecretKey = getEnvWithDefault("FAUCET_TURNSTILE_SECRET", "your-turnstile-secret-key")
-	config.Security.TurnstileSiteKey = getEnvWithDefault("FAUCET_TURNSTILE_SITE", "your-turnstile-site-key")
-	config.Security.RateLimitRequests = getEnvIntWithDefault("FAUCET_RATE_LIMIT_REQUESTS", 5)
-	config.Security.RateLimitDuration = getEnvIntWithDefault("FAUCET_RATE_LIMIT_DURATION", 60)
-	config.Security.ClaimCooldown = getEnvIntWithDefault("FAUCET_CLAIM_COOLDOWN", 86400)
+	config.Security.CaptchaProvider = env.getWithDefault("FAUCET_CAPTCHA_PROVIDER", config.Security.CaptchaProvider)
+	// The Turnstile variables predate the other providers and still work
+	config.Security.CaptchaSecretKey = env.getWithDefault("FAUCET_CAPTCHA_SECRET", env.getWithDefault("FAUCET_TURNSTILE_SECRET", config.Security.CaptchaSecretKey))
+	config.Security.CaptchaSiteKey = env.getWithDefault("FAUCET_CAPTCHA_SITE", env.getWithDefault("FAUCET_TURNSTILE_SITE", config.Security.CaptchaSiteKey))
+	config.Security.CaptchaVerifyURL = env.getWithDefault("FAUCET_CAPTCHA_VERIFY_URL", config.Security.CaptchaVerifyURL)
+	config.Security.CaptchaMinScore = env.getFloatWithDefault("FAUCET_RECAPTCHA_MIN_SCORE", config.Security.CaptchaMinScore)
+	config.Security.CaptchaHostnames = env.getListWithDefault("FAUCET_CAPTCHA_HOSTNAMES", config.Security.CaptchaHostnames)
+	config.Security.CaptchaAction = env.getWithDefault("FAUCET_CAPTCHA_ACTION", config.Security.CaptchaAction)
+	config.Security.CaptchaDevBypass = env.getBoolWithDefault("FAUCET_CAPTCHA_DEV_BYPASS", config.Security.CaptchaDevBypass)
+	config.Security.RateLimitRequests = env.getIntWithDefault("FAUCET_RATE_LIMIT_REQUESTS", config.Security.RateLimitRequests)
+	config.Security.RateLimitDuration = env.getIntWithDefault("FAUCET_RATE_LIMIT_DURATION", config.Security.RateLimitDuration)
+	config.Security.StatusRateLimit = env.getIntWithDefault("FAUCET_STATUS_RATE_LIMIT", config.Security.StatusRateLimit)
+	config.Security.ReadRateLimit = env.getIntWithDefault("FAUCET_READ_RATE_LIMIT", config.Security.ReadRateLimit)
+	config.Security.ClaimCooldown = env.getIntWithDefault("FAUCET_CLAIM_COOLDOWN", config.Security.ClaimCooldown)
+	config.Security.IPClaimCooldown = env.getIntWithDefault("FAUCET_IP_CLAIM_COOLDOWN", config.Security.IPClaimCooldown)
+	config.Security.IPDailyClaimLimit = env.getIntWithDefault("FAUCET_IP_DAILY_CLAIM_LIMIT", config.Security.IPDailyClaimLimit)
+	config.Security.IPv4SubnetPrefix = env.getIntWithDefault("FAUCET_IPV4_SUBNET_PREFIX", config.Security.IPv4SubnetPrefix)
+	config.Security.IPv6SubnetPrefix = env.getIntWithDefault("FAUCET_IPV6_SUBNET_PREFIX", config.Security.IPv6SubnetPrefix)
+	config.Security.TrustedProxies = env.getListWithDefault("FAUCET_TRUSTED_PROXIES", config.Security.TrustedProxies)
+	config.Security.CloudflareProxies = env.getListWithDefault("FAUCET_CLOUDFLARE_PROXIES", config.Security.CloudflareProxies)
+	config.Security.ClientIPHeaders = env.getListWithDefault("FAUCET_CLIENT_IP_HEADERS", config.Security.ClientIPHeaders)
+	config.Security.IdempotencyWindow = env.getIntWithDefault("FAUCET_IDEMPOTENCY_WINDOW", config.Security.IdempotencyWindow)
+	config.Security.PowEnabled = env.getBoolWithDefault("FAUCET_POW_ENABLED", config.Security.PowEnabled)
+	config.Security.PowSecret = env.getWithDefault("FAUCET_POW_SECRET", config.Security.PowSecret)
+	config.Security.PowDifficulty = env.getIntWithDefault("FAUCET_POW_DIFFICULTY", config.Security.PowDifficulty)
+	config.Security.PowMaxDifficulty = env.getIntWithDefault("FAUCET_POW_MAX_DIFFICULTY", config.Security.PowMaxDifficulty)
+	config.Security.PowChallengeTTL = env.getIntWithDefault("FAUCET_POW_CHALLENGE_TTL", config.Security.PowChallengeTTL)
+	config.Security.PowVolumeThreshold = env.getIntWithDefault("FAUCET_POW_VOLUME_THRESHOLD", config.Security.PowVolumeThreshold)
+	config.Security.AdminToken = env.getWithDefault("FAUCET_ADMIN_TOKEN", config.Security.AdminToken)
 
 	// CORS config
-	allowedOrigins := getEnvWithDefault("FAUCET_CORS_ALLOWED_ORIGINS", "http://localhost:3000,https://https://solana-faucet.maestroi.cc/")
-	config.CORS.AllowedOrigins = strings.Split(allowedOrigins, ",")
+	config.CORS.AllowedOrigins = env.getListWithDefault("FAUCET_CORS_ALLOWED_ORIGINS", config.CORS.AllowedOrigins)
+
+	// JSON-RPC config
+	config.RPC.Enabled = env.getBoolWithDefault("FAUCET_RPC_ENABLED", config.RPC.Enabled)
+	config.RPC.RequireAPIKey = env.getBoolWithDefault("FAUCET_RPC_REQUIRE_API_KEY", config.RPC.RequireAPIKey)
+
+	// Claim queue config
+	config.Queue.Workers = env.getIntWithDefault("FAUCET_QUEUE_WORKERS", config.Queue.Workers)
+	config.Queue.BatchSize = env.getIntWithDefault("FAUCET_QUEUE_BATCH_SIZE", config.Queue.BatchSize)
+	config.Queue.PollInterval = env.getIntWithDefault("FAUCET_QUEUE_POLL_INTERVAL_MS", config.Queue.PollInterval)
+	config.Queue.WaitTimeout = env.getIntWithDefault("FAUCET_QUEUE_WAIT_TIMEOUT", config.Queue.WaitTimeout)
+
+	// Sign-in config
+	config.Auth.Enabled = env.getBoolWithDefault("FAUCET_AUTH_ENABLED", config.Auth.Enabled)
+	config.Auth.SessionSecret = env.getWithDefault("FAUCET_SESSION_SECRET", config.Auth.SessionSecret)
+	config.Auth.SessionTTL = env.getIntWithDefault("FAUCET_SESSION_TTL", config.Auth.SessionTTL)
+	config.Auth.CookieSecure = env.getBoolWithDefault("FAUCET_SESSION_COOKIE_SECURE", config.Auth.CookieSecure)
+	config.Auth.PublicURL = env.getWithDefault("FAUCET_PUBLIC_URL", config.Auth.PublicURL)
+	config.Auth.RedirectURL = env.getWithDefault("FAUCET_AUTH_REDIRECT_URL", config.Auth.RedirectURL)
+	config.Auth.GitHubClientID = env.getWithDefault("FAUCET_GITHUB_CLIENT_ID", config.Auth.GitHubClientID)
+	config.Auth.GitHubClientSecret = env.getWithDefault("FAUCET_GITHUB_CLIENT_SECRET", config.Auth.GitHubClientSecret)
+	config.Auth.GitHubAuthorizeURL = env.getWithDefault("FAUCET_GITHUB_AUTHORIZE_URL", config.Auth.GitHubAuthorizeURL)
+	config.Auth.GitHubTokenURL = env.getWithDefault("FAUCET_GITHUB_TOKEN_URL", config.Auth.GitHubTokenURL)
+	config.Auth.GitHubUserURL = env.getWithDefault("FAUCET_GITHUB_USER_URL", config.Auth.GitHubUserURL)
+	config.Auth.MinAccountAge = env.getIntWithDefault("FAUCET_GITHUB_MIN_ACCOUNT_AGE", config.Auth.MinAccountAge)
+	config.Auth.Amount = env.getFloatWithDefault("FAUCET_AUTH_AMOUNT", config.Auth.Amount)
+	config.Auth.DailyClaims = env.getIntWithDefault("FAUCET_AUTH_DAILY_CLAIMS", config.Auth.DailyClaims)
+	config.Auth.Cooldown = env.getIntWithDefault("FAUCET_AUTH_COOLDOWN", config.Auth.Cooldown)
+
+	// Signer config
+	config.Signer.URL = env.getWithDefault("FAUCET_SIGNER_URL", config.Signer.URL)
+	config.Signer.Token = env.getWithDefault("FAUCET_SIGNER_TOKEN", config.Signer.Token)
+	config.Signer.Timeout = env.getIntWithDefault("FAUCET_SIGNER_TIMEOUT", config.Signer.Timeout)
+	config.Signer.Listen = env.getWithDefault("FAUCET_SIGNER_LISTEN", config.Signer.Listen)
+	config.Signer.MaxLamportsPerTx = env.getUintWithDefault("FAUCET_SIGNER_MAX_LAMPORTS_PER_TX", config.Signer.MaxLamportsPerTx, 64)
+	config.Signer.MaxLamportsPerHour = env.getUintWithDefault("FAUCET_SIGNER_MAX_LAMPORTS_PER_HOUR", config.Signer.MaxLamportsPerHour, 64)
+	config.Signer.AllowedPrograms = env.getListWithDefault("FAUCET_SIGNER_ALLOWED_PROGRAMS", config.Signer.AllowedPrograms)
+
+	// Token config, e.g. [{"symbol":"USDC","mint":"...","amount":100,"cooldown":86400}]
+	if tokens := os.Getenv("FAUCET_TOKENS"); tokens != "" {
+		config.Tokens = nil
+		if err := json.Unmarshal([]byte(tokens), &config.Tokens); err != nil {
+			env.errs = append(env.errs, fmt.Errorf("FAUCET_TOKENS: %w", err))
+		}
+	}
+
+	// Malformed variables are reported by Validate along with every other
+	// problem, rather than one at a time
+	config.envErrors = env.errs
+	return config, nil
+}
+
+// DefaultConfig returns the built-in configuration every other source is
+// layered over
+func DefaultConfig() *Config {
+	config := &Config{}
+	config.Server.Address = "0.0.0.0"
+	config.Server.Port = 8080
+	config.Database.Path = "/app/data/faucet.db"
+	config.Solana.RpcURL = "https://api.testnet.solana.com"
+	config.Solana.RpcEndpoints = []RPCEndpoint{}
+	config.Solana.RpcTimeout = 10
+	config.Solana.HealthCheckInterval = 30
+	config.Solana.FaucetWalletPath = "/app/data/wallet.json"
+	config.Solana.WalletDerivationPath = wallet.DefaultDerivationPath
+	config.Solana.AmountPerRequest = 1.0
+	config.Solana.NetworkType = "testnet"
+	config.Solana.TransactionTimeout = 30
+	config.Solana.SendMaxAttempts = 3
+	config.Solana.RebroadcastInterval = 2000
+	config.Solana.RetryBackoff = 500
+	config.Solana.ComputeUnitPrice = 0
+	config.Solana.DynamicPriorityFee = false
+	config.Solana.PriorityFeePercentile = 75
+	config.Solana.MaxPriorityFee = 100000
+	config.Solana.ComputeUnitLimit = 0
+	config.Solana.Commitment = "confirmed"
+	config.Solana.ConfirmInterval = 2
+	config.Security.CaptchaProvider = "turnstile"
+	config.Security.CaptchaSecretKey = ""
+	config.Security.CaptchaSiteKey = "your-turnstile-site-key"
+	config.Security.CaptchaVerifyURL = ""
+	config.Security.CaptchaMinScore = 0.5
+	config.Security.CaptchaHostnames = []string{}
+	config.Security.CaptchaAction = ""
+	config.Security.CaptchaDevBypass = false
+	config.Security.RateLimitRequests = 5
+	config.Security.RateLimitDuration = 60
+	config.Security.StatusRateLimit = 60
+	config.Security.ReadRateLimit = 120
+	config.Security.ClaimCooldown = 86400 // 24 hours in seconds
+	config.Security.IPClaimCooldown = 600 // 10 minutes in seconds
+	config.Security.IPDailyClaimLimit = 5
+	config.Security.IPv4SubnetPrefix = 24
+	config.Security.IPv6SubnetPrefix = 64
+	config.Security.TrustedProxies = []string{}
+	config.Security.CloudflareProxies = []string{}
+	config.Security.ClientIPHeaders = []string{"X-Forwarded-For"}
+	config.Security.IdempotencyWindow = 86400
+	config.Security.PowEnabled = false
+	config.Security.PowSecret = ""
+	config.Security.PowDifficulty = 20
+	config.Security.PowMaxDifficulty = 26
+	config.Security.PowChallengeTTL = 300
+	config.Security.PowVolumeThreshold = 60
+	config.Security.AdminToken = ""
+	config.CORS.AllowedOrigins = []string{"http://localhost:3000", "https://solana-faucet.maestroi.cc"}
+	config.RPC.Enabled = false
+	config.RPC.RequireAPIKey = false
+	config.Queue.Workers = 2
+	config.Queue.BatchSize = 10
+	config.Queue.PollInterval = 1000
+	config.Queue.WaitTimeout = 25
+	config.Auth.Enabled = false
+	config.Auth.SessionSecret = ""
+	config.Auth.SessionTTL = 604800
+	config.Auth.CookieSecure = true
+	config.Auth.PublicURL = "http://localhost:8080"
+	config.Auth.RedirectURL = "http://localhost:3000"
+	config.Auth.GitHubClientID = ""
+	config.Auth.GitHubClientSecret = ""
+	config.Auth.GitHubAuthorizeURL = ""
+	config.Auth.GitHubTokenURL = ""
+	config.Auth.GitHubUserURL = ""
+	config.Auth.MinAccountAge = 30
+	config.Auth.Amount = 5.0
+	config.Auth.DailyClaims = 3
+	config.Auth.Cooldown = 3600
+	config.Signer.URL = ""
+	config.Signer.Token = ""
+	config.Signer.Timeout = 10
+	config.Signer.Listen = "127.0.0.1:8090"
+	config.Signer.MaxLamportsPerTx = 0
+	config.Signer.MaxLamportsPerHour = 0
+	config.Signer.AllowedPrograms = []string{}
+	config.Tokens = []TokenConfig{}
+	return config
+}
+
+// parseRPCEndpoints parses a comma-separated list of RPC URLs, each
+// optionally followed by "|weight"
+func parseRPCEndpoints(value string) ([]RPCEndpoint, error) {
+	var endpoints []RPCEndpoint
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
 
-	return &config, nil
+		endpoint := RPCEndpoint{URL: item, Weight: 1}
+		if url, weight, ok := strings.Cut(item, "|"); ok {
+			w, err := strconv.Atoi(strings.TrimSpace(weight))
+			if err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid weight for RPC endpoint %q", url)
+			}
+			endpoint = RPCEndpoint{URL: strings.TrimSpace(url), Weight: w}
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints, nil
+}
+
+// Endpoints returns the configured RPC endpoints, falling back to RpcURL
+func (c *Config) Endpoints() []RPCEndpoint {
+	if len(c.Solana.RpcEndpoints) > 0 {
+		return c.Solana.RpcEndpoints
+	}
+	return []RPCEndpoint{{URL: c.Solana.RpcURL, Weight: 1}}
 }
 
-func getEnvWithDefault(key, defaultValue string) string {
+// envReader reads settings from environment variables, keeping the default
+// for unset ones and collecting the errors for malformed ones
+type envReader struct {
+	errs []error
+}
+
+func (e *envReader) getWithDefault(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
 	return defaultValue
 }
 
-func getEnvIntWithDefault(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+func (e *envReader) getListWithDefault(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
 		}
 	}
-	return defaultValue
+	return list
 }
 
-func getEnvFloatWithDefault(key string, defaultValue float64) float64 {
+func (e *envReader) getIntWithDefault(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
-		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
-			return floatValue
+		intValue, err := strconv.Atoi(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not an integer", key, value))
+			return defaultValue
 		}
+		return intValue
 	}
 	return defaultValue
 }
 
-// CreateDefaultConfig creates a default configuration file if one doesn't exist
-func CreateDefaultConfig(path string) error {
-	// Check if file already exists
-	if _, err := os.Stat(path); err == nil {
-		return nil // File exists, no need to create
+func (e *envReader) getUintWithDefault(key string, defaultValue uint64, bitSize int) uint64 {
+	if value := os.Getenv(key); value != "" {
+		uintValue, err := strconv.ParseUint(value, 10, bitSize)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not a non-negative %d-bit integer", key, value, bitSize))
+			return defaultValue
+		}
+		return uintValue
 	}
+	return defaultValue
+}
 
-	// Create a default configuration
-	config := Config{}
-	config.Server.Address = "0.0.0.0"
-	config.Server.Port = 8080
-	config.Database.Path = "faucet.db"
-	config.Solana.RpcURL = "https://api.testnet.solana.com"
-	config.Solana.FaucetWalletPath = "wallet.json"
-	config.Solana.AmountPerRequest = 1.0
-	config.Solana.NetworkType = "testnet"
-	config.Solana.TransactionTimeout = 30
-	config.Security.TurnstileSecretKey = "your-turnstile-secret-key"
-	config.Security.TurnstileSiteKey = "your-turnstile-site-key"
-	config.Security.RateLimitRequests = 5
-	config.Security.RateLimitDuration = 60
-	config.Security.ClaimCooldown = 86400 // 24 hours in seconds
-
-	// Create the file
-	file, err := os.Create(path)
-	if err != nil {
-		return err
+func (e *envReader) getFloatWithDefault(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		floatValue, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not a number", key, value))
+			return defaultValue
+		}
+		return floatValue
 	}
-	defer file.Close()
+	return defaultValue
+}
 
-	// Encode the configuration to JSON
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(config); err != nil {
-		return err
+func (e *envReader) getBoolWithDefault(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		boolValue, err := strconv.ParseBool(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %q is not a boolean", key, value))
+			return defaultValue
+		}
+		return boolValue
 	}
-
-	return nil
+	return defaultValue
 }
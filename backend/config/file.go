@@ -0,0 +1,180 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Placeholders for secrets in the printed configuration; URLs get one
+// without brackets so it isn't percent-encoded
+const (
+	redacted    = "[REDACTED]"
+	redactedURL = "REDACTED"
+)
+
+// fileFormat returns the config file format for a path from its extension
+func fileFormat(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return "json", nil
+	case ".yaml", ".yml":
+		return "yaml", nil
+	case ".toml":
+		return "toml", nil
+	default:
+		return "", fmt.Errorf("unsupported config file %q, use .json, .yaml, .yml or .toml", path)
+	}
+}
+
+// loadFile layers a JSON, YAML or TOML config file over config. Keys match
+// field names case-insensitively and may use snake_case or kebab-case, so
+// "rpc_url", "rpcUrl" and "RpcURL" all set Solana.RpcURL. Settings missing
+// from the file keep their current value; unknown keys are an error so typos
+// don't go unnoticed.
+func loadFile(path string, config *Config) error {
+	format, err := fileFormat(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Decode into generic values first so all three formats share the key
+	// matching of encoding/json
+	var raw interface{}
+	switch format {
+	case "json":
+		err = json.Unmarshal(data, &raw)
+	case "yaml":
+		err = yaml.Unmarshal(data, &raw)
+	case "toml":
+		var table map[string]interface{}
+		err = toml.Unmarshal(data, &table)
+		raw = table
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	if raw == nil {
+		return nil // empty file
+	}
+
+	normalized, err := json.Marshal(normalizeKeys(raw))
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(normalized))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// normalizeKeys strips "_" and "-" from map keys, recursively, so they match
+// Go field names
+func normalizeKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[normalizeKey(key)] = normalizeKeys(item)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[normalizeKey(fmt.Sprint(key))] = normalizeKeys(item)
+		}
+		return out
+	case []map[string]interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalizeKeys(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = normalizeKeys(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
+
+// normalizeKey removes word separators from a config key
+func normalizeKey(key string) string {
+	return strings.NewReplacer("_", "", "-", "").Replace(key)
+}
+
+// Redacted returns a copy of the configuration that is safe to log, with
+// secrets and RPC URL credentials masked
+func (c *Config) Redacted() *Config {
+	out := *c
+	out.Security.CaptchaSecretKey = redact(c.Security.CaptchaSecretKey)
+	out.Security.PowSecret = redact(c.Security.PowSecret)
+	out.Security.AdminToken = redact(c.Security.AdminToken)
+	out.Auth.SessionSecret = redact(c.Auth.SessionSecret)
+	out.Solana.WalletSecretKey = redact(c.Solana.WalletSecretKey)
+	out.Solana.WalletMnemonic = redact(c.Solana.WalletMnemonic)
+	out.Solana.WalletMnemonicPassphrase = redact(c.Solana.WalletMnemonicPassphrase)
+	out.Solana.WalletPassphrase = redact(c.Solana.WalletPassphrase)
+	out.Signer.Token = redact(c.Signer.Token)
+	out.Auth.GitHubClientSecret = redact(c.Auth.GitHubClientSecret)
+
+	// RPC providers commonly put API keys in the URL
+	out.Solana.RpcURL = redactURL(c.Solana.RpcURL)
+	out.Solana.RpcEndpoints = make([]RPCEndpoint, len(c.Solana.RpcEndpoints))
+	for i, e := range c.Solana.RpcEndpoints {
+		out.Solana.RpcEndpoints[i] = RPCEndpoint{URL: redactURL(e.URL), Weight: e.Weight}
+	}
+	return &out
+}
+
+// String renders the redacted configuration as indented JSON
+func (c *Config) String() string {
+	data, err := json.MarshalIndent(c.Redacted(), "", "  ")
+	if err != nil {
+		return fmt.Sprintf("<invalid config: %v>", err)
+	}
+	return string(data)
+}
+
+// redact masks a secret, leaving unset ones visibly empty
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactURL masks the credentials, path and query of a URL, any of which
+// may carry an API key
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return redact(raw)
+	}
+	if u.User != nil {
+		u.User = url.User(redactedURL)
+	}
+	if u.Path != "" && u.Path != "/" {
+		u.Path = "/" + redactedURL
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = redactedURL
+	}
+	return u.String()
+}
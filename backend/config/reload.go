@@ -0,0 +1,121 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// WithReloadable returns a copy of c with the settings that can change
+// without a restart taken from next: amounts, cooldowns and claim limits,
+// rate limits, CORS origins, captcha settings and proof-of-work difficulty.
+// Everything else, such as the listen address, wallet, RPC endpoints and
+// secrets the server derives state from, keeps its current value.
+func (c *Config) WithReloadable(next *Config) *Config {
+	out := *c
+
+	out.Solana.AmountPerRequest = next.Solana.AmountPerRequest
+
+	out.Security.CaptchaProvider = next.Security.CaptchaProvider
+	out.Security.CaptchaSecretKey = next.Security.CaptchaSecretKey
+	out.Security.CaptchaSiteKey = next.Security.CaptchaSiteKey
+	out.Security.CaptchaVerifyURL = next.Security.CaptchaVerifyURL
+	out.Security.CaptchaMinScore = next.Security.CaptchaMinScore
+	out.Security.CaptchaHostnames = next.Security.CaptchaHostnames
+	out.Security.CaptchaAction = next.Security.CaptchaAction
+	out.Security.CaptchaDevBypass = next.Security.CaptchaDevBypass
+	out.Security.RateLimitRequests = next.Security.RateLimitRequests
+	out.Security.RateLimitDuration = next.Security.RateLimitDuration
+	out.Security.StatusRateLimit = next.Security.StatusRateLimit
+	out.Security.ReadRateLimit = next.Security.ReadRateLimit
+	out.Security.ClaimCooldown = next.Security.ClaimCooldown
+	out.Security.IPClaimCooldown = next.Security.IPClaimCooldown
+	out.Security.IPDailyClaimLimit = next.Security.IPDailyClaimLimit
+	out.Security.IPv4SubnetPrefix = next.Security.IPv4SubnetPrefix
+	out.Security.IPv6SubnetPrefix = next.Security.IPv6SubnetPrefix
+	out.Security.IdempotencyWindow = next.Security.IdempotencyWindow
+	out.Security.PowDifficulty = next.Security.PowDifficulty
+	out.Security.PowMaxDifficulty = next.Security.PowMaxDifficulty
+	out.Security.PowVolumeThreshold = next.Security.PowVolumeThreshold
+
+	out.CORS = next.CORS
+
+	out.Auth.MinAccountAge = next.Auth.MinAccountAge
+	out.Auth.Amount = next.Auth.Amount
+	out.Auth.DailyClaims = next.Auth.DailyClaims
+	out.Auth.Cooldown = next.Auth.Cooldown
+
+	out.Tokens = next.Tokens
+
+	return &out
+}
+
+// Diff lists the settings that differ between c and next, one per line as
+// "Section.Field: old -> new". Secrets are not shown, only that they changed.
+func (c *Config) Diff(next *Config) []string {
+	before, after := flatten(c), flatten(next)
+	shownBefore, shownAfter := flatten(c.Redacted()), flatten(next.Redacted())
+
+	keys := map[string]bool{}
+	for k := range before {
+		keys[k] = true
+	}
+	for k := range after {
+		keys[k] = true
+	}
+
+	var changes []string
+	for k := range keys {
+		if before[k] == after[k] {
+			continue
+		}
+		if shownBefore[k] == shownAfter[k] {
+			changes = append(changes, fmt.Sprintf("%s: changed (redacted)", k))
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s: %s -> %s", k, orNone(shownBefore[k]), orNone(shownAfter[k])))
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// flatten renders each setting of a config as "Section.Field" -> JSON value.
+// Lists are compared as a whole.
+func flatten(c *Config) map[string]string {
+	out := map[string]string{}
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		section := v.Field(i)
+		if section.Kind() != reflect.Struct {
+			out[field.Name] = jsonString(section.Interface())
+			continue
+		}
+		for j := 0; j < section.NumField(); j++ {
+			out[field.Name+"."+section.Type().Field(j).Name] = jsonString(section.Field(j).Interface())
+		}
+	}
+	return out
+}
+
+// jsonString renders a setting for the diff
+func jsonString(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// orNone marks settings missing on one side of a diff
+func orNone(value string) string {
+	if value == "" {
+		return "(none)"
+	}
+	return value
+}
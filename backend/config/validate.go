@@ -0,0 +1,312 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/maestroi/solana-faucet/backend/utils"
+	"github.com/maestroi/solana-faucet/backend/wallet"
+)
+
+// genesisHashes are the genesis hashes of the public Solana clusters, by
+// network type. Other network types, such as a local validator, aren't
+// checked.
+var genesisHashes = map[string]string{
+	"mainnet-beta": "5eykt4UsFv8P8NJdTREpY1vzqKqZKvdpKuc147dw2N9d",
+	"mainnet":      "5eykt4UsFv8P8NJdTREpY1vzqKqZKvdpKuc147dw2N9d",
+	"devnet":       "EtWTRABZaYq6iMfeYKouRu166VU2xqa1wcaWoxPkrZBG",
+	"testnet":      "4uhcVJyU9pJkvQyS88uRDiswHXSCkY3zQawwpjk2NsNY",
+}
+
+// genesisTimeout bounds the genesis hash lookup per RPC endpoint
+const genesisTimeout = 5 * time.Second
+
+// ValidationError lists every problem found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// validator collects configuration problems
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+func (v *validator) positive(name string, value int) {
+	if value <= 0 {
+		v.addf("%s must be positive, got %d", name, value)
+	}
+}
+
+func (v *validator) nonNegative(name string, value int) {
+	if value < 0 {
+		v.addf("%s must not be negative, got %d", name, value)
+	}
+}
+
+func (v *validator) between(name string, value, min, max int) {
+	if value < min || value > max {
+		v.addf("%s must be between %d and %d, got %d", name, min, max, value)
+	}
+}
+
+func (v *validator) oneOf(name, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.addf("%s must be one of %s, got %q", name, strings.Join(allowed, ", "), value)
+}
+
+// httpURL checks that value is an absolute http(s) URL
+func (v *validator) httpURL(name, value string) bool {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		v.addf("%s must be an http(s) URL, got %q", name, value)
+		return false
+	}
+	return true
+}
+
+// Validate checks the configuration and reports every problem at once,
+// including environment variables LoadConfig could not parse. It makes no
+// network calls, so it is cheap enough for reloads and -print-config.
+func (c *Config) Validate() error {
+	v := &validator{}
+	for _, err := range c.envErrors {
+		v.addf("%v", err)
+	}
+
+	// Server
+	v.between("Server.Port", c.Server.Port, 1, 65535)
+	if c.Database.Path == "" {
+		v.addf("Database.Path must be set")
+	}
+
+	// Solana
+	if len(c.Solana.RpcEndpoints) == 0 {
+		v.httpURL("Solana.RpcURL", c.Solana.RpcURL)
+	}
+	for i, e := range c.Solana.RpcEndpoints {
+		v.httpURL(fmt.Sprintf("Solana.RpcEndpoints[%d].URL", i), e.URL)
+		if e.Weight <= 0 {
+			v.addf("Solana.RpcEndpoints[%d].Weight must be positive, got %d", i, e.Weight)
+		}
+	}
+	v.positive("Solana.RpcTimeout", c.Solana.RpcTimeout)
+	v.nonNegative("Solana.HealthCheckInterval", c.Solana.HealthCheckInterval)
+	if c.Solana.AmountPerRequest <= 0 {
+		v.addf("Solana.AmountPerRequest must be positive, got %g", c.Solana.AmountPerRequest)
+	}
+	// The API process doesn't load the wallet when signing remotely
+	if c.Signer.URL == "" {
+		if err := wallet.Check(c.WalletOptions()); err != nil {
+			v.addf("Solana wallet (%s): %v", c.WalletOptions().Source(), err)
+		}
+	}
+	if c.Solana.NetworkType == "" {
+		v.addf("Solana.NetworkType must be set")
+	}
+	v.positive("Solana.TransactionTimeout", c.Solana.TransactionTimeout)
+	v.oneOf("Solana.Commitment", c.Solana.Commitment, "processed", "confirmed", "finalized")
+	v.positive("Solana.ConfirmInterval", c.Solana.ConfirmInterval)
+	v.positive("Solana.SendMaxAttempts", c.Solana.SendMaxAttempts)
+	v.nonNegative("Solana.RebroadcastInterval", c.Solana.RebroadcastInterval)
+	v.nonNegative("Solana.RetryBackoff", c.Solana.RetryBackoff)
+	v.between("Solana.PriorityFeePercentile", c.Solana.PriorityFeePercentile, 0, 100)
+
+	// Security
+	v.oneOf("Security.CaptchaProvider", c.Security.CaptchaProvider,
+		utils.CaptchaTurnstile, utils.CaptchaHCaptcha, utils.CaptchaRecaptcha, utils.CaptchaRecaptchaV3, utils.CaptchaNone)
+	if c.Security.CaptchaSecretKey == "" && !c.Security.CaptchaDevBypass && c.Security.CaptchaProvider != utils.CaptchaNone {
+		v.addf("Security.CaptchaSecretKey is required for the %s captcha (set FAUCET_CAPTCHA_DEV_BYPASS=true to run without one locally)", c.Security.CaptchaProvider)
+	}
+	if c.Security.CaptchaVerifyURL != "" {
+		v.httpURL("Security.CaptchaVerifyURL", c.Security.CaptchaVerifyURL)
+	}
+	if c.Security.CaptchaMinScore < 0 || c.Security.CaptchaMinScore > 1 {
+		v.addf("Security.CaptchaMinScore must be between 0 and 1, got %g", c.Security.CaptchaMinScore)
+	}
+	v.nonNegative("Security.RateLimitRequests", c.Security.RateLimitRequests)
+	v.nonNegative("Security.RateLimitDuration", c.Security.RateLimitDuration)
+	v.nonNegative("Security.StatusRateLimit", c.Security.StatusRateLimit)
+	v.nonNegative("Security.ReadRateLimit", c.Security.ReadRateLimit)
+	v.nonNegative("Security.ClaimCooldown", c.Security.ClaimCooldown)
+	v.nonNegative("Security.IPClaimCooldown", c.Security.IPClaimCooldown)
+	v.nonNegative("Security.IPDailyClaimLimit", c.Security.IPDailyClaimLimit)
+	v.between("Security.IPv4SubnetPrefix", c.Security.IPv4SubnetPrefix, 0, 32)
+	v.between("Security.IPv6SubnetPrefix", c.Security.IPv6SubnetPrefix, 0, 128)
+	for _, proxy := range c.Security.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			v.addf("Security.TrustedProxies: %q is not an IP address or CIDR", proxy)
+		}
+	}
+	for _, proxy := range c.Security.CloudflareProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			v.addf("Security.CloudflareProxies: %q is not an IP address or CIDR", proxy)
+		}
+	}
+	for _, header := range c.Security.ClientIPHeaders {
+		if (strings.EqualFold(header, "CF-Connecting-IP") || strings.EqualFold(header, "True-Client-IP")) && len(c.Security.CloudflareProxies) == 0 {
+			v.addf("Security.ClientIPHeaders: %s is only believed from Security.CloudflareProxies, which is empty", header)
+		}
+	}
+	v.nonNegative("Security.IdempotencyWindow", c.Security.IdempotencyWindow)
+	if c.Security.PowEnabled {
+		v.between("Security.PowDifficulty", c.Security.PowDifficulty, 1, 64)
+		v.between("Security.PowMaxDifficulty", c.Security.PowMaxDifficulty, c.Security.PowDifficulty, 64)
+		v.positive("Security.PowChallengeTTL", c.Security.PowChallengeTTL)
+		v.nonNegative("Security.PowVolumeThreshold", c.Security.PowVolumeThreshold)
+	}
+
+	// CORS
+	for _, origin := range c.CORS.AllowedOrigins {
+		if err := checkOrigin(origin); err != nil {
+			v.addf("CORS.AllowedOrigins: %v", err)
+		}
+	}
+
+	// JSON-RPC airdrops have no captcha; anonymous ones need the IP limits
+	if c.RPC.Enabled && !c.RPC.RequireAPIKey && c.Security.IPClaimCooldown <= 0 && c.Security.IPDailyClaimLimit <= 0 {
+		v.addf("RPC.RequireAPIKey may only be disabled with Security.IPClaimCooldown or Security.IPDailyClaimLimit set")
+	}
+
+	// Claim queue. requestAirdrop waits within the 30 second request timeout.
+	v.positive("Queue.Workers", c.Queue.Workers)
+	v.between("Queue.BatchSize", c.Queue.BatchSize, 1, utils.MaxSOLBatchSize)
+	v.positive("Queue.PollInterval", c.Queue.PollInterval)
+	v.between("Queue.WaitTimeout", c.Queue.WaitTimeout, 1, 25)
+
+	// Sign-in
+	if c.Auth.Enabled {
+		if c.Auth.GitHubClientID == "" || c.Auth.GitHubClientSecret == "" {
+			v.addf("Auth.GitHubClientID and Auth.GitHubClientSecret must be set when sign-in is enabled")
+		}
+		v.httpURL("Auth.PublicURL", c.Auth.PublicURL)
+		v.httpURL("Auth.RedirectURL", c.Auth.RedirectURL)
+		for name, value := range map[string]string{
+			"Auth.GitHubAuthorizeURL": c.Auth.GitHubAuthorizeURL,
+			"Auth.GitHubTokenURL":     c.Auth.GitHubTokenURL,
+			"Auth.GitHubUserURL":      c.Auth.GitHubUserURL,
+		} {
+			if value != "" {
+				v.httpURL(name, value)
+			}
+		}
+		v.positive("Auth.SessionTTL", c.Auth.SessionTTL)
+		v.nonNegative("Auth.MinAccountAge", c.Auth.MinAccountAge)
+		if c.Auth.Amount < 0 {
+			v.addf("Auth.Amount must not be negative, got %g", c.Auth.Amount)
+		}
+		v.nonNegative("Auth.DailyClaims", c.Auth.DailyClaims)
+		v.nonNegative("Auth.Cooldown", c.Auth.Cooldown)
+	}
+
+	// Signer
+	if c.Signer.URL != "" {
+		v.httpURL("Signer.URL", c.Signer.URL)
+	}
+	v.positive("Signer.Timeout", c.Signer.Timeout)
+	if c.Signer.Listen == "" {
+		v.addf("Signer.Listen must be set")
+	} else if _, _, err := net.SplitHostPort(c.Signer.Listen); err != nil {
+		v.addf("Signer.Listen %q: %v", c.Signer.Listen, err)
+	}
+	if _, err := c.SignerPolicy(); err != nil {
+		v.addf("Signer.AllowedPrograms: %v", err)
+	}
+
+	// Tokens
+	symbols := map[string]bool{"SOL": true}
+	for i, t := range c.Tokens {
+		name := fmt.Sprintf("Tokens[%d]", i)
+		symbol := strings.ToUpper(t.Symbol)
+		if symbol == "" {
+			v.addf("%s.Symbol must be set", name)
+		} else if symbols[symbol] {
+			v.addf("%s.Symbol %q is used more than once", name, t.Symbol)
+		}
+		symbols[symbol] = true
+		if !utils.IsValidSolanaAddress(t.Mint) {
+			v.addf("%s.Mint %q is not a valid address", name, t.Mint)
+		}
+		if t.Program != "" {
+			v.oneOf(name+".Program", t.Program, utils.TokenProgramSPL, utils.TokenProgramToken2022)
+		}
+		if t.Amount <= 0 {
+			v.addf("%s.Amount must be positive, got %g", name, t.Amount)
+		}
+		v.nonNegative(name+".Cooldown", t.Cooldown)
+		if t.MaxPerTx != 0 && t.MaxPerTx < t.Amount {
+			v.addf("%s.MaxPerTx must be 0 or at least Amount, got %g", name, t.MaxPerTx)
+		}
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// checkOrigin checks a CORS origin is "*" or a bare scheme://host[:port],
+// which is all a browser's Origin header ever carries
+func checkOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%q is not an http(s) origin", origin)
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return fmt.Errorf("%q must be scheme://host[:port] only, with no path or trailing slash", origin)
+	}
+	if port := u.Port(); port == "" && strings.HasSuffix(u.Host, ":") {
+		return fmt.Errorf("%q has an empty port", origin)
+	}
+	return nil
+}
+
+// CheckGenesis compares each RPC endpoint's genesis hash with the one of
+// the configured network, since the faucet would otherwise be labelled as
+// handing out e.g. testnet SOL while paying on devnet. It calls every
+// endpoint, so it runs once at startup after Validate rather than on each
+// reload. Unreachable endpoints are skipped: failover deals with them at
+// runtime.
+func (c *Config) CheckGenesis() error {
+	want, ok := genesisHashes[c.Solana.NetworkType]
+	if !ok {
+		return nil
+	}
+
+	var problems []string
+	for _, e := range c.Endpoints() {
+		ctx, cancel := context.WithTimeout(context.Background(), genesisTimeout)
+		hash, err := rpc.New(e.URL).GetGenesisHash(ctx)
+		cancel()
+		if err != nil {
+			continue
+		}
+		if got := hash.String(); got != want {
+			problems = append(problems, fmt.Sprintf("RPC endpoint %s is not on %s (genesis hash %s, want %s)",
+				redactURL(e.URL), c.Solana.NetworkType, got, want))
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
@@ -2,6 +2,10 @@ package db
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/maestroi/solana-faucet/backend/models"
@@ -119,7 +123,15 @@ func (d *Database) migrateClaimHistoryTimestamps() error {
 
 // InitDB initializes the database
 func InitDB(dbPath string) (*Database, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// Claim workers write concurrently with the HTTP handlers, so wait for
+	// the write lock instead of failing with "database is locked", and take
+	// it when a transaction begins so claim reservations are serialized
+	dsn := dbPath
+	if !strings.Contains(dsn, "?") {
+		dsn += "?_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+	}
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -157,11 +169,17 @@ func createTables(db *sql.DB) error {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		wallet_address TEXT NOT NULL,
 		ip_address TEXT NOT NULL,
+		asset TEXT NOT NULL DEFAULT 'SOL',
 		amount REAL NOT NULL,
 		status TEXT NOT NULL,
 		tx_hash TEXT,
 		error_message TEXT,
-		timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_valid_block_height INTEGER NOT NULL DEFAULT 0,
+		fee_lamports INTEGER NOT NULL DEFAULT 0,
+		api_key_id INTEGER NOT NULL DEFAULT 0,
+		identity TEXT NOT NULL DEFAULT '',
+		subnet TEXT NOT NULL DEFAULT ''
 	);
 	`
 	if _, err := db.Exec(transactionTableSQL); err != nil {
@@ -173,38 +191,181 @@ func createTables(db *sql.DB) error {
 	CREATE TABLE IF NOT EXISTS claim_history (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		wallet_address TEXT NOT NULL,
+		asset TEXT NOT NULL DEFAULT 'SOL',
 		ip_address TEXT NOT NULL,
 		last_claim_time TIMESTAMP NOT NULL,
 		claim_count INTEGER NOT NULL DEFAULT 1,
-		UNIQUE(wallet_address)
+		UNIQUE(wallet_address, asset)
 	);
 	`
 	if _, err := db.Exec(claimHistoryTableSQL); err != nil {
 		return err
 	}
 
+	// Create idempotency_keys table
+	idempotencyKeysTableSQL := `
+	CREATE TABLE IF NOT EXISTS idempotency_keys (
+		key TEXT PRIMARY KEY,
+		wallet_address TEXT NOT NULL,
+		asset TEXT NOT NULL,
+		transaction_id INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP NOT NULL
+	);
+	`
+	if _, err := db.Exec(idempotencyKeysTableSQL); err != nil {
+		return err
+	}
+
+	// Create api_keys table
+	apiKeysTableSQL := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		key_hash TEXT NOT NULL UNIQUE,
+		prefix TEXT NOT NULL,
+		daily_quota INTEGER NOT NULL DEFAULT 0,
+		amount REAL NOT NULL DEFAULT 0,
+		networks TEXT NOT NULL DEFAULT '',
+		assets TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP
+	);
+	`
+	if _, err := db.Exec(apiKeysTableSQL); err != nil {
+		return err
+	}
+
+	// Claim history created before per-asset cooldowns is keyed on the wallet
+	// alone and has to be rebuilt to change its unique constraint
+	if err := migrateClaimHistoryAssets(db); err != nil {
+		return err
+	}
+
+	// Add columns introduced after the tables were first created
+	if err := addColumnIfMissing(db, "transactions", "last_valid_block_height", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "transactions", "asset", "TEXT NOT NULL DEFAULT 'SOL'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "transactions", "fee_lamports", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "transactions", "api_key_id", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "transactions", "identity", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(db, "transactions", "subnet", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// The subnet limits are checked on every anonymous claim
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_transactions_subnet ON transactions (subnet, asset, timestamp)`); err != nil {
+		return err
+	}
+
 	return nil
 }
 
+// addColumnIfMissing adds a column to an existing table unless it already exists
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// hasColumn reports whether a table has the given column
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    int
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+
+	return false, rows.Err()
+}
+
+// migrateClaimHistoryAssets rebuilds a claim_history table without an asset
+// column, carrying every existing row over as a SOL claim
+func migrateClaimHistoryAssets(db *sql.DB) error {
+	exists, err := hasColumn(db, "claim_history", "asset")
+	if err != nil || exists {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`CREATE TABLE claim_history_new (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			wallet_address TEXT NOT NULL,
+			asset TEXT NOT NULL DEFAULT 'SOL',
+			ip_address TEXT NOT NULL,
+			last_claim_time TIMESTAMP NOT NULL,
+			claim_count INTEGER NOT NULL DEFAULT 1,
+			UNIQUE(wallet_address, asset)
+		)`,
+		`INSERT INTO claim_history_new (id, wallet_address, asset, ip_address, last_claim_time, claim_count)
+		SELECT id, wallet_address, 'SOL', ip_address, last_claim_time, claim_count FROM claim_history`,
+		`DROP TABLE claim_history`,
+		`ALTER TABLE claim_history_new RENAME TO claim_history`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
 // Close closes the database connection
 func (d *Database) Close() error {
 	return d.db.Close()
 }
 
-// GetClaimHistory retrieves the claim history for a wallet
-func (d *Database) GetClaimHistory(walletAddress string) (*models.ClaimHistory, error) {
+// GetClaimHistory retrieves the claim history for a wallet and asset
+func (d *Database) GetClaimHistory(walletAddress, asset string) (*models.ClaimHistory, error) {
 	query := `
-	SELECT id, wallet_address, ip_address, last_claim_time, claim_count
+	SELECT id, wallet_address, asset, ip_address, last_claim_time, claim_count
 	FROM claim_history
-	WHERE wallet_address = ?
+	WHERE wallet_address = ? AND asset = ?
 	`
 
-	row := d.db.QueryRow(query, walletAddress)
+	row := d.db.QueryRow(query, walletAddress, asset)
 
 	var ch models.ClaimHistory
 	var lastClaimTime string
 
-	err := row.Scan(&ch.ID, &ch.WalletAddress, &ch.IPAddress, &lastClaimTime, &ch.ClaimCount)
+	err := row.Scan(&ch.ID, &ch.WalletAddress, &ch.Asset, &ch.IPAddress, &lastClaimTime, &ch.ClaimCount)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -229,7 +390,7 @@ func (d *Database) GetClaimHistory(walletAddress string) (*models.ClaimHistory,
 // GetClaimHistoryByIP retrieves the claim history for an IP address
 func (d *Database) GetClaimHistoryByIP(ipAddress string) ([]*models.ClaimHistory, error) {
 	query := `
-	SELECT id, wallet_address, ip_address, last_claim_time, claim_count
+	SELECT id, wallet_address, asset, ip_address, last_claim_time, claim_count
 	FROM claim_history
 	WHERE ip_address = ?
 	`
@@ -246,7 +407,7 @@ func (d *Database) GetClaimHistoryByIP(ipAddress string) ([]*models.ClaimHistory
 		var ch models.ClaimHistory
 		var lastClaimTime string
 
-		if err := rows.Scan(&ch.ID, &ch.WalletAddress, &ch.IPAddress, &lastClaimTime, &ch.ClaimCount); err != nil {
+		if err := rows.Scan(&ch.ID, &ch.WalletAddress, &ch.Asset, &ch.IPAddress, &lastClaimTime, &ch.ClaimCount); err != nil {
 			return nil, err
 		}
 
@@ -267,75 +428,429 @@ func (d *Database) GetClaimHistoryByIP(ipAddress string) ([]*models.ClaimHistory
 	return histories, nil
 }
 
-// UpdateClaimHistory updates or creates a claim history record
-func (d *Database) UpdateClaimHistory(walletAddress, ipAddress string) error {
-	// Check if record exists
-	history, err := d.GetClaimHistory(walletAddress)
+// ErrSubnetLimited is returned by ReserveClaim when the claim's subnet is
+// within its cooldown or has reached its daily cap
+var ErrSubnetLimited = errors.New("subnet claim limit reached")
+
+// SubnetLimits caps anonymous claims per client subnet and asset
+type SubnetLimits struct {
+	Cooldown    int // in seconds between claims, 0 disables
+	DailyClaims int // claims per 24 hours, 0 disables
+}
+
+// ReserveClaim atomically checks a wallet's cooldown for an asset and, if it
+// has passed, starts a new cooldown and records the queued transaction. The
+// check and the reservation are one conditional upsert, so concurrent
+// requests for the same wallet can't both get through. When the wallet is
+// still cooling down it returns a zero ID and the current claim history.
+//
+// Claims with a subnet are also held to the subnet limits, counted in the
+// same transaction as the reservation. When the subnet is limited it returns
+// ErrSubnetLimited.
+func (d *Database) ReserveClaim(tx *models.Transaction, cooldownSeconds int, limits SubnetLimits) (int64, *models.ClaimHistory, error) {
+	asset := tx.Asset
+	if asset == "" {
+		asset = "SOL"
+	}
+
+	// Transactions take the write lock when they begin, so the subnet count
+	// can't change until this reservation commits
+	dbTx, err := d.db.Begin()
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
+	defer dbTx.Rollback()
 
-	if history == nil {
-		// Create new record
+	if tx.Subnet != "" && (limits.Cooldown > 0 || limits.DailyClaims > 0) {
 		query := `
-		INSERT INTO claim_history (wallet_address, ip_address, last_claim_time, claim_count)
-		VALUES (?, ?, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'), 1)
+		SELECT (? > 0 AND EXISTS (
+			SELECT 1 FROM transactions
+			WHERE subnet = ? AND asset = ? AND status != 'failed' AND api_key_id = 0 AND identity = ''
+				AND timestamp >= strftime('%Y-%m-%dT%H:%M:%SZ', 'now', ?)
+		)) OR (? > 0 AND (
+			SELECT COUNT(*) FROM transactions
+			WHERE subnet = ? AND asset = ? AND status != 'failed' AND api_key_id = 0 AND identity = ''
+				AND timestamp >= strftime('%Y-%m-%dT%H:%M:%SZ', 'now', '-1 day')
+		) >= ?)
 		`
-		_, err := d.db.Exec(query, walletAddress, ipAddress)
-		return err
+		var limited bool
+		err := dbTx.QueryRow(
+			query,
+			limits.Cooldown, tx.Subnet, asset, fmt.Sprintf("-%d seconds", limits.Cooldown),
+			limits.DailyClaims, tx.Subnet, asset, limits.DailyClaims,
+		).Scan(&limited)
+		if err != nil {
+			return 0, nil, err
+		}
+		if limited {
+			return 0, nil, ErrSubnetLimited
+		}
 	}
 
-	// Update existing record
+	// Start the cooldown unless the last claim is still within it
 	query := `
-	UPDATE claim_history
-	SET last_claim_time = strftime('%Y-%m-%dT%H:%M:%SZ', 'now'), 
-		ip_address = ?,
+	INSERT INTO claim_history (wallet_address, asset, ip_address, last_claim_time, claim_count)
+	VALUES (?, ?, ?, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'), 1)
+	ON CONFLICT(wallet_address, asset) DO UPDATE SET
+		last_claim_time = excluded.last_claim_time,
+		ip_address = excluded.ip_address,
 		claim_count = claim_count + 1
-	WHERE wallet_address = ?
+	WHERE claim_history.last_claim_time <= strftime('%Y-%m-%dT%H:%M:%SZ', 'now', ?)
 	`
-	_, err = d.db.Exec(query, ipAddress, walletAddress)
-	return err
-}
+	result, err := dbTx.Exec(query, tx.WalletAddress, asset, tx.IPAddress, fmt.Sprintf("-%d seconds", cooldownSeconds))
+	if err != nil {
+		return 0, nil, err
+	}
+	reserved, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+	if reserved == 0 {
+		dbTx.Rollback()
+		history, err := d.GetClaimHistory(tx.WalletAddress, asset)
+		return 0, history, err
+	}
 
-// CreateTransaction creates a new transaction record
-func (d *Database) CreateTransaction(tx *models.Transaction) (int64, error) {
-	query := `
-	INSERT INTO transactions (wallet_address, ip_address, amount, status, tx_hash, error_message, timestamp)
-	VALUES (?, ?, ?, ?, ?, ?, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+	// Record the claim in the same transaction as its reservation
+	query = `
+	INSERT INTO transactions (wallet_address, ip_address, asset, amount, status, tx_hash, error_message, last_valid_block_height, subnet, timestamp)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
 	`
-
-	result, err := d.db.Exec(
+	result, err = dbTx.Exec(
 		query,
 		tx.WalletAddress,
 		tx.IPAddress,
+		asset,
 		tx.Amount,
 		tx.Status,
 		tx.TxHash,
 		tx.ErrorMessage,
+		tx.LastValidBlockHeight,
+		tx.Subnet,
 	)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
+	}
+	txID, err := result.LastInsertId()
+	if err != nil {
+		return 0, nil, err
 	}
 
-	return result.LastInsertId()
+	return txID, nil, dbTx.Commit()
+}
+
+// FailClaim marks a claim failed and, for anonymous claims, releases its
+// wallet cooldown in the same transaction, so the wallet isn't held to a
+// cooldown for funds it never received. The cooldown falls back to the
+// wallet's last claim that wasn't a failure, if any. Claims made with an
+// API key or by a signed-in user never reserved one; their failed rows
+// simply stop counting.
+func (d *Database) FailClaim(tx *models.Transaction) error {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	query := `
+	UPDATE transactions
+	SET status = 'failed', tx_hash = ?, error_message = ?, fee_lamports = ?, last_valid_block_height = ?
+	WHERE id = ?
+	`
+	if _, err := dbTx.Exec(query, tx.TxHash, tx.ErrorMessage, tx.FeeLamports, tx.LastValidBlockHeight, tx.ID); err != nil {
+		return err
+	}
+
+	if tx.APIKeyID == 0 && tx.Identity == "" {
+		asset := tx.Asset
+		if asset == "" {
+			asset = "SOL"
+		}
+		if err := releaseClaim(dbTx, tx.WalletAddress, asset); err != nil {
+			return err
+		}
+	}
+
+	return dbTx.Commit()
+}
+
+// releaseClaim rolls a wallet's cooldown back to its last claim that
+// wasn't a failure, within a transaction
+func releaseClaim(dbTx *sql.Tx, walletAddress, asset string) error {
+	query := `
+	UPDATE claim_history
+	SET claim_count = claim_count - 1,
+		last_claim_time = COALESCE((
+			SELECT MAX(timestamp) FROM transactions
+			WHERE wallet_address = ? AND asset = ? AND status != 'failed'
+		), last_claim_time)
+	WHERE wallet_address = ? AND asset = ?
+	`
+	if _, err := dbTx.Exec(query, walletAddress, asset, walletAddress, asset); err != nil {
+		return err
+	}
+
+	query = `
+	DELETE FROM claim_history
+	WHERE wallet_address = ? AND asset = ? AND claim_count <= 0
+	`
+	_, err := dbTx.Exec(query, walletAddress, asset)
+	return err
 }
 
 // UpdateTransaction updates an existing transaction
 func (d *Database) UpdateTransaction(tx *models.Transaction) error {
 	query := `
 	UPDATE transactions
-	SET status = ?, tx_hash = ?, error_message = ?
+	SET status = ?, tx_hash = ?, error_message = ?, fee_lamports = ?, last_valid_block_height = ?
 	WHERE id = ?
 	`
 
-	_, err := d.db.Exec(query, tx.Status, tx.TxHash, tx.ErrorMessage, tx.ID)
+	_, err := d.db.Exec(query, tx.Status, tx.TxHash, tx.ErrorMessage, tx.FeeLamports, tx.LastValidBlockHeight, tx.ID)
 	return err
 }
 
+// RecordSignature attaches the signature of a signed but not yet broadcast
+// transaction to the claims it pays, leaving them in "sending". Saving it
+// first means a send interrupted after broadcasting can still be settled by
+// its signature.
+func (d *Database) RecordSignature(ids []int64, txHash string, lastValidBlockHeight uint64) error {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer dbTx.Rollback()
+
+	for _, id := range ids {
+		if _, err := dbTx.Exec(`
+		UPDATE transactions
+		SET tx_hash = ?, last_valid_block_height = ?
+		WHERE id = ? AND status = 'sending'
+		`, txHash, lastValidBlockHeight, id); err != nil {
+			return err
+		}
+	}
+
+	return dbTx.Commit()
+}
+
+// ResumeSignedTransactions moves claims left in "sending" with a signature
+// back to "pending", so the confirmer settles them by their signature instead
+// of guessing whether they landed. It is meant to run at startup, before
+// FailUnsentTransactions.
+func (d *Database) ResumeSignedTransactions() (int64, error) {
+	query := `
+	UPDATE transactions
+	SET status = 'pending'
+	WHERE status = 'sending' AND tx_hash IS NOT NULL AND tx_hash != ''
+	`
+
+	result, err := d.db.Exec(query)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// FailUnsentTransactions marks claims that were being sent but never got a
+// signature as failed. It is meant to run at startup, when any such row was
+// left behind by a send interrupted by a crash or restart; since signatures
+// are recorded before broadcasting, none of them reached the cluster. Queued
+// claims are left alone so the workers pick them up again.
+func (d *Database) FailUnsentTransactions(errorMessage string) (int64, error) {
+	query := `
+	UPDATE transactions
+	SET status = 'failed', error_message = ?
+	WHERE status IN ('sending', 'pending') AND (tx_hash IS NULL OR tx_hash = '')
+	`
+
+	result, err := d.db.Exec(query, errorMessage)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ClaimNextQueuedTransaction atomically moves the oldest queued claim to
+// "sending" and returns it, or nil if the queue is empty. A single UPDATE
+// guarantees two workers never pick up the same claim.
+func (d *Database) ClaimNextQueuedTransaction() (*models.Transaction, error) {
+	query := `
+	UPDATE transactions
+	SET status = 'sending'
+	WHERE id = (SELECT id FROM transactions WHERE status = 'queued' ORDER BY id ASC LIMIT 1)
+	RETURNING id, wallet_address, ip_address, asset, amount, status, tx_hash, error_message, fee_lamports, last_valid_block_height, api_key_id, identity, timestamp
+	`
+
+	row := d.db.QueryRow(query)
+
+	var tx models.Transaction
+	var timestamp string
+
+	err := row.Scan(
+		&tx.ID,
+		&tx.WalletAddress,
+		&tx.IPAddress,
+		&tx.Asset,
+		&tx.Amount,
+		&tx.Status,
+		&tx.TxHash,
+		&tx.ErrorMessage,
+		&tx.FeeLamports,
+		&tx.LastValidBlockHeight,
+		&tx.APIKeyID,
+		&tx.Identity,
+		&timestamp,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the timestamp using RFC3339 format
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		// Try the old format as fallback
+		t, err = time.Parse("2006-01-02 15:04:05", timestamp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tx.Timestamp = t
+
+	return &tx, nil
+}
+
+// ClaimQueuedTransactions atomically moves up to limit of the oldest queued
+// claims for an asset to "sending" and returns them, oldest first. It is
+// used to fill a batch after ClaimNextQueuedTransaction.
+func (d *Database) ClaimQueuedTransactions(asset string, limit int) ([]*models.Transaction, error) {
+	query := `
+	UPDATE transactions
+	SET status = 'sending'
+	WHERE id IN (SELECT id FROM transactions WHERE status = 'queued' AND asset = ? ORDER BY id ASC LIMIT ?)
+	RETURNING id, wallet_address, ip_address, asset, amount, status, tx_hash, error_message, fee_lamports, last_valid_block_height, api_key_id, identity, timestamp
+	`
+
+	rows, err := d.db.Query(query, asset, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []*models.Transaction
+
+	for rows.Next() {
+		var tx models.Transaction
+		var timestamp string
+
+		if err := rows.Scan(
+			&tx.ID,
+			&tx.WalletAddress,
+			&tx.IPAddress,
+			&tx.Asset,
+			&tx.Amount,
+			&tx.Status,
+			&tx.TxHash,
+			&tx.ErrorMessage,
+			&tx.FeeLamports,
+			&tx.LastValidBlockHeight,
+			&tx.APIKeyID,
+			&tx.Identity,
+			&timestamp,
+		); err != nil {
+			return nil, err
+		}
+
+		// Parse the timestamp using RFC3339 format
+		t, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			// Try the old format as fallback
+			t, err = time.Parse("2006-01-02 15:04:05", timestamp)
+			if err != nil {
+				return nil, err
+			}
+		}
+		tx.Timestamp = t
+
+		transactions = append(transactions, &tx)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// RETURNING doesn't guarantee an order
+	sort.Slice(transactions, func(i, j int) bool { return transactions[i].ID < transactions[j].ID })
+
+	return transactions, nil
+}
+
+// GetPendingTransactions retrieves sent transactions that are still awaiting
+// confirmation, oldest first
+func (d *Database) GetPendingTransactions(limit int) ([]*models.Transaction, error) {
+	query := `
+	SELECT id, wallet_address, ip_address, asset, amount, status, tx_hash, error_message, fee_lamports, last_valid_block_height, api_key_id, identity, timestamp
+	FROM transactions
+	WHERE status = 'pending' AND tx_hash != ''
+	ORDER BY id ASC
+	LIMIT ?
+	`
+
+	rows, err := d.db.Query(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var transactions []*models.Transaction
+
+	for rows.Next() {
+		var tx models.Transaction
+		var timestamp string
+
+		if err := rows.Scan(
+			&tx.ID,
+			&tx.WalletAddress,
+			&tx.IPAddress,
+			&tx.Asset,
+			&tx.Amount,
+			&tx.Status,
+			&tx.TxHash,
+			&tx.ErrorMessage,
+			&tx.FeeLamports,
+			&tx.LastValidBlockHeight,
+			&tx.APIKeyID,
+			&tx.Identity,
+			&timestamp,
+		); err != nil {
+			return nil, err
+		}
+
+		// Parse the timestamp using RFC3339 format
+		t, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			// Try the old format as fallback
+			t, err = time.Parse("2006-01-02 15:04:05", timestamp)
+			if err != nil {
+				return nil, err
+			}
+		}
+		tx.Timestamp = t
+
+		transactions = append(transactions, &tx)
+	}
+
+	return transactions, nil
+}
+
 // GetRecentTransactions retrieves recent transactions
 func (d *Database) GetRecentTransactions(limit int) ([]*models.Transaction, error) {
 	query := `
-	SELECT id, wallet_address, amount, status, tx_hash, error_message, timestamp
+	SELECT id, wallet_address, asset, amount, status, tx_hash, error_message, fee_lamports, timestamp
 	FROM transactions
 	ORDER BY timestamp DESC
 	LIMIT ?
@@ -356,10 +871,12 @@ func (d *Database) GetRecentTransactions(limit int) ([]*models.Transaction, erro
 		if err := rows.Scan(
 			&tx.ID,
 			&tx.WalletAddress,
+			&tx.Asset,
 			&tx.Amount,
 			&tx.Status,
 			&tx.TxHash,
 			&tx.ErrorMessage,
+			&tx.FeeLamports,
 			&timestamp,
 		); err != nil {
 			return nil, err
@@ -385,7 +902,7 @@ func (d *Database) GetRecentTransactions(limit int) ([]*models.Transaction, erro
 // GetTransactionsByWallet retrieves transactions for a specific wallet
 func (d *Database) GetTransactionsByWallet(walletAddress string, limit int) ([]*models.Transaction, error) {
 	query := `
-	SELECT id, wallet_address, amount, status, tx_hash, error_message, timestamp
+	SELECT id, wallet_address, asset, amount, status, tx_hash, error_message, fee_lamports, timestamp
 	FROM transactions
 	WHERE wallet_address = ?
 	ORDER BY timestamp DESC
@@ -407,10 +924,12 @@ func (d *Database) GetTransactionsByWallet(walletAddress string, limit int) ([]*
 		if err := rows.Scan(
 			&tx.ID,
 			&tx.WalletAddress,
+			&tx.Asset,
 			&tx.Amount,
 			&tx.Status,
 			&tx.TxHash,
 			&tx.ErrorMessage,
+			&tx.FeeLamports,
 			&timestamp,
 		); err != nil {
 			return nil, err
@@ -432,3 +951,476 @@ func (d *Database) GetTransactionsByWallet(walletAddress string, limit int) ([]*
 
 	return transactions, nil
 }
+
+// CountTransactionsSince returns the number of claims made since the given
+// time, not counting failed ones
+func (d *Database) CountTransactionsSince(since time.Time) (int, error) {
+	query := `
+	SELECT COUNT(*)
+	FROM transactions
+	WHERE timestamp >= ? AND status != 'failed'
+	`
+
+	var count int
+	err := d.db.QueryRow(query, since.UTC().Format(time.RFC3339)).Scan(&count)
+	return count, err
+}
+
+// GetTransaction retrieves a transaction by its ID
+func (d *Database) GetTransaction(id int64) (*models.Transaction, error) {
+	query := `
+	SELECT id, wallet_address, asset, amount, status, tx_hash, error_message, fee_lamports, timestamp
+	FROM transactions
+	WHERE id = ?
+	`
+
+	row := d.db.QueryRow(query, id)
+
+	var tx models.Transaction
+	var timestamp string
+
+	err := row.Scan(
+		&tx.ID,
+		&tx.WalletAddress,
+		&tx.Asset,
+		&tx.Amount,
+		&tx.Status,
+		&tx.TxHash,
+		&tx.ErrorMessage,
+		&tx.FeeLamports,
+		&timestamp,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the timestamp using RFC3339 format
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		// Try the old format as fallback
+		t, err = time.Parse("2006-01-02 15:04:05", timestamp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tx.Timestamp = t
+
+	return &tx, nil
+}
+
+// GetTransactionByHash retrieves a transaction by its on-chain signature
+func (d *Database) GetTransactionByHash(txHash string) (*models.Transaction, error) {
+	query := `
+	SELECT id, wallet_address, asset, amount, status, tx_hash, error_message, fee_lamports, timestamp
+	FROM transactions
+	WHERE tx_hash = ?
+	`
+
+	row := d.db.QueryRow(query, txHash)
+
+	var tx models.Transaction
+	var timestamp string
+
+	err := row.Scan(
+		&tx.ID,
+		&tx.WalletAddress,
+		&tx.Asset,
+		&tx.Amount,
+		&tx.Status,
+		&tx.TxHash,
+		&tx.ErrorMessage,
+		&tx.FeeLamports,
+		&timestamp,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse the timestamp using RFC3339 format
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		// Try the old format as fallback
+		t, err = time.Parse("2006-01-02 15:04:05", timestamp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tx.Timestamp = t
+
+	return &tx, nil
+}
+
+// ReserveIdempotencyKey records a new idempotency key for a request, or
+// returns the existing record if the key was used within the window. The
+// bool reports whether the key was newly reserved. Expired keys are pruned.
+func (d *Database) ReserveIdempotencyKey(key, walletAddress, asset string, window time.Duration) (*models.IdempotencyKey, bool, error) {
+	dbTx, err := d.db.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer dbTx.Rollback()
+
+	// Forget keys older than the replay window
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE created_at < strftime('%Y-%m-%dT%H:%M:%SZ', 'now', ?)
+	`
+	if _, err := dbTx.Exec(query, fmt.Sprintf("-%d seconds", int(window.Seconds()))); err != nil {
+		return nil, false, err
+	}
+
+	query = `
+	INSERT OR IGNORE INTO idempotency_keys (key, wallet_address, asset, transaction_id, created_at)
+	VALUES (?, ?, ?, 0, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+	`
+	result, err := dbTx.Exec(query, key, walletAddress, asset)
+	if err != nil {
+		return nil, false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, false, err
+	}
+
+	query = `
+	SELECT key, wallet_address, asset, transaction_id, created_at
+	FROM idempotency_keys
+	WHERE key = ?
+	`
+	var record models.IdempotencyKey
+	var createdAt string
+	if err := dbTx.QueryRow(query, key).Scan(
+		&record.Key,
+		&record.WalletAddress,
+		&record.Asset,
+		&record.TransactionID,
+		&createdAt,
+	); err != nil {
+		return nil, false, err
+	}
+
+	// Parse the timestamp using RFC3339 format
+	t, err := time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		return nil, false, err
+	}
+	record.CreatedAt = t
+
+	if err := dbTx.Commit(); err != nil {
+		return nil, false, err
+	}
+
+	return &record, inserted == 1, nil
+}
+
+// CompleteIdempotencyKey attaches the claim created by a request to its key
+func (d *Database) CompleteIdempotencyKey(key string, transactionID int64) error {
+	query := `
+	UPDATE idempotency_keys
+	SET transaction_id = ?
+	WHERE key = ?
+	`
+
+	_, err := d.db.Exec(query, transactionID, key)
+	return err
+}
+
+// DeleteIdempotencyKey frees a key whose request didn't create a claim, so
+// the client can retry it
+func (d *Database) DeleteIdempotencyKey(key string) error {
+	_, err := d.db.Exec(`DELETE FROM idempotency_keys WHERE key = ? AND transaction_id = 0`, key)
+	return err
+}
+
+// CreateAPIKey stores a new API key by its hash and returns its ID
+func (d *Database) CreateAPIKey(key *models.APIKey, keyHash string) (int64, error) {
+	query := `
+	INSERT INTO api_keys (name, key_hash, prefix, daily_quota, amount, networks, assets, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, strftime('%Y-%m-%dT%H:%M:%SZ', 'now'))
+	`
+
+	result, err := d.db.Exec(
+		query,
+		key.Name,
+		keyHash,
+		key.Prefix,
+		key.DailyQuota,
+		key.Amount,
+		strings.Join(key.Networks, ","),
+		strings.Join(key.Assets, ","),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// GetAPIKeyByHash retrieves an unrevoked API key by its hash and records
+// that it was used
+func (d *Database) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	query := `
+	SELECT id, name, prefix, daily_quota, amount, networks, assets, created_at, last_used_at, revoked_at
+	FROM api_keys
+	WHERE key_hash = ? AND revoked_at IS NULL
+	`
+
+	key, err := scanAPIKey(d.db.QueryRow(query, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.db.Exec(`UPDATE api_keys SET last_used_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now') WHERE id = ?`, key.ID)
+	return key, err
+}
+
+// ListAPIKeys retrieves every API key, including revoked ones
+func (d *Database) ListAPIKeys() ([]*models.APIKey, error) {
+	query := `
+	SELECT id, name, prefix, daily_quota, amount, networks, assets, created_at, last_used_at, revoked_at
+	FROM api_keys
+	ORDER BY id ASC
+	`
+
+	rows, err := d.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes an API key, reporting whether an active key was found
+func (d *Database) RevokeAPIKey(id int64) (bool, error) {
+	query := `
+	UPDATE api_keys
+	SET revoked_at = strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+	WHERE id = ? AND revoked_at IS NULL
+	`
+
+	result, err := d.db.Exec(query, id)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CreateKeyedTransaction records a queued claim made with an API key, unless
+// the key has already used its daily quota. The quota check and the insert
+// are one statement, so concurrent claims can't overshoot it. It returns a
+// zero ID when the quota is exhausted.
+func (d *Database) CreateKeyedTransaction(tx *models.Transaction, dailyQuota int) (int64, error) {
+	asset := tx.Asset
+	if asset == "" {
+		asset = "SOL"
+	}
+
+	query := `
+	INSERT INTO transactions (wallet_address, ip_address, asset, amount, status, tx_hash, error_message, last_valid_block_height, api_key_id, timestamp)
+	SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+	WHERE ? <= 0 OR (
+		SELECT COUNT(*) FROM transactions
+		WHERE api_key_id = ? AND status != 'failed'
+			AND timestamp >= strftime('%Y-%m-%dT%H:%M:%SZ', 'now', '-1 day')
+	) < ?
+	`
+
+	result, err := d.db.Exec(
+		query,
+		tx.WalletAddress,
+		tx.IPAddress,
+		asset,
+		tx.Amount,
+		tx.Status,
+		tx.TxHash,
+		tx.ErrorMessage,
+		tx.LastValidBlockHeight,
+		tx.APIKeyID,
+		dailyQuota,
+		tx.APIKeyID,
+		dailyQuota,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// CreateIdentityTransaction records a queued claim made by a signed-in user,
+// unless the identity claimed the asset within the cooldown or has used its
+// daily claims. The check and the insert are one statement, so concurrent
+// claims can't get around either limit. It returns a zero ID when a limit
+// was hit.
+func (d *Database) CreateIdentityTransaction(tx *models.Transaction, dailyClaims, cooldownSeconds int) (int64, error) {
+	asset := tx.Asset
+	if asset == "" {
+		asset = "SOL"
+	}
+
+	query := `
+	INSERT INTO transactions (wallet_address, ip_address, asset, amount, status, tx_hash, error_message, last_valid_block_height, identity, timestamp)
+	SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, strftime('%Y-%m-%dT%H:%M:%SZ', 'now')
+	WHERE (? <= 0 OR NOT EXISTS (
+		SELECT 1 FROM transactions
+		WHERE identity = ? AND asset = ? AND status != 'failed'
+			AND timestamp >= strftime('%Y-%m-%dT%H:%M:%SZ', 'now', ?)
+	)) AND (? <= 0 OR (
+		SELECT COUNT(*) FROM transactions
+		WHERE identity = ? AND asset = ? AND status != 'failed'
+			AND timestamp >= strftime('%Y-%m-%dT%H:%M:%SZ', 'now', '-1 day')
+	) < ?)
+	`
+
+	result, err := d.db.Exec(
+		query,
+		tx.WalletAddress,
+		tx.IPAddress,
+		asset,
+		tx.Amount,
+		tx.Status,
+		tx.TxHash,
+		tx.ErrorMessage,
+		tx.LastValidBlockHeight,
+		tx.Identity,
+		cooldownSeconds,
+		tx.Identity,
+		asset,
+		fmt.Sprintf("-%d seconds", cooldownSeconds),
+		dailyClaims,
+		tx.Identity,
+		asset,
+		dailyClaims,
+	)
+	if err != nil {
+		return 0, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return 0, err
+	}
+
+	return result.LastInsertId()
+}
+
+// GetSubnetClaimTimes returns when anonymous claims for an asset were made
+// from a subnet since the given time, oldest first. Failed claims don't
+// count.
+func (d *Database) GetSubnetClaimTimes(subnet, asset string, since time.Time) ([]time.Time, error) {
+	query := `
+	SELECT timestamp FROM transactions
+	WHERE subnet = ? AND asset = ? AND status != 'failed' AND api_key_id = 0 AND identity = '' AND timestamp >= ?
+	ORDER BY timestamp ASC
+	`
+	return d.queryTimes(query, subnet, asset, since.UTC().Format(time.RFC3339))
+}
+
+// GetIdentityClaimTimes returns when an identity claimed an asset since the
+// given time, oldest first. Failed claims don't count.
+func (d *Database) GetIdentityClaimTimes(identity, asset string, since time.Time) ([]time.Time, error) {
+	query := `
+	SELECT timestamp FROM transactions
+	WHERE identity = ? AND asset = ? AND status != 'failed' AND timestamp >= ?
+	ORDER BY timestamp ASC
+	`
+
+	return d.queryTimes(query, identity, asset, since.UTC().Format(time.RFC3339))
+}
+
+// queryTimes runs a query selecting a single timestamp column
+func (d *Database) queryTimes(query string, args ...interface{}) ([]time.Time, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var times []time.Time
+	for rows.Next() {
+		var timestamp string
+		if err := rows.Scan(&timestamp); err != nil {
+			return nil, err
+		}
+		t, err := time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+
+	return times, rows.Err()
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIKey reads an API key row
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	var key models.APIKey
+	var networks, assets, createdAt string
+	var lastUsedAt, revokedAt sql.NullString
+
+	if err := row.Scan(
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.DailyQuota,
+		&key.Amount,
+		&networks,
+		&assets,
+		&createdAt,
+		&lastUsedAt,
+		&revokedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if networks != "" {
+		key.Networks = strings.Split(networks, ",")
+	}
+	if assets != "" {
+		key.Assets = strings.Split(assets, ",")
+	}
+
+	t, err := time.Parse(time.RFC3339, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	key.CreatedAt = t
+	if lastUsedAt.Valid {
+		if t, err := time.Parse(time.RFC3339, lastUsedAt.String); err == nil {
+			key.LastUsedAt = &t
+		}
+	}
+	if revokedAt.Valid {
+		if t, err := time.Parse(time.RFC3339, revokedAt.String); err == nil {
+			key.RevokedAt = &t
+		}
+	}
+
+	return &key, nil
+}
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/maestroi/solana-faucet/backend/models"
+)
+
+// newTestDB opens a fresh database file in a temporary directory
+func newTestDB(t *testing.T) *Database {
+	t.Helper()
+	d, err := InitDB(filepath.Join(t.TempDir(), "faucet.db"))
+	if err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+// race runs fn from n goroutines at once and counts how many succeeded
+func race(t *testing.T, n int, fn func(i int) (bool, error)) int {
+	t.Helper()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			ok, err := fn(i)
+			if err != nil {
+				t.Errorf("goroutine %d: %v", i, err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				succeeded++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	return succeeded
+}
+
+func queuedClaim(wallet, subnet string) *models.Transaction {
+	return &models.Transaction{
+		WalletAddress: wallet,
+		IPAddress:     "203.0.113.7",
+		Asset:         "SOL",
+		Amount:        0.1,
+		Status:        "queued",
+		Subnet:        subnet,
+	}
+}
+
+func TestReserveClaimConcurrentWallet(t *testing.T) {
+	d := newTestDB(t)
+
+	succeeded := race(t, 20, func(i int) (bool, error) {
+		id, _, err := d.ReserveClaim(queuedClaim("wallet-1", ""), 3600, SubnetLimits{})
+		return id > 0, err
+	})
+	if succeeded != 1 {
+		t.Fatalf("%d claims for one wallet got through, want 1", succeeded)
+	}
+}
+
+func TestReserveClaimConcurrentSubnet(t *testing.T) {
+	d := newTestDB(t)
+
+	succeeded := race(t, 20, func(i int) (bool, error) {
+		tx := queuedClaim(fmt.Sprintf("wallet-%d", i), "203.0.113.0/24")
+		id, _, err := d.ReserveClaim(tx, 3600, SubnetLimits{DailyClaims: 1})
+		if errors.Is(err, ErrSubnetLimited) {
+			return false, nil
+		}
+		return id > 0, err
+	})
+	if succeeded != 1 {
+		t.Fatalf("%d claims from one subnet got through, want 1", succeeded)
+	}
+}
+
+func TestCreateKeyedTransactionConcurrent(t *testing.T) {
+	d := newTestDB(t)
+
+	succeeded := race(t, 20, func(i int) (bool, error) {
+		tx := queuedClaim(fmt.Sprintf("wallet-%d", i), "")
+		tx.APIKeyID = 7
+		id, err := d.CreateKeyedTransaction(tx, 1)
+		return id > 0, err
+	})
+	if succeeded != 1 {
+		t.Fatalf("%d claims with a quota of 1 got through, want 1", succeeded)
+	}
+}
+
+func TestFailClaimReleasesCooldown(t *testing.T) {
+	d := newTestDB(t)
+
+	tx := queuedClaim("wallet-1", "")
+	id, _, err := d.ReserveClaim(tx, 3600, SubnetLimits{})
+	if err != nil || id == 0 {
+		t.Fatalf("ReserveClaim = %d, %v", id, err)
+	}
+	tx.ID = id
+	tx.Status = "failed"
+	tx.ErrorMessage = "boom"
+	if err := d.FailClaim(tx); err != nil {
+		t.Fatalf("FailClaim: %v", err)
+	}
+
+	id, _, err = d.ReserveClaim(queuedClaim("wallet-1", ""), 3600, SubnetLimits{})
+	if err != nil || id == 0 {
+		t.Fatalf("claim after a failed one = %d, %v, want a new claim", id, err)
+	}
+}
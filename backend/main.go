@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -15,14 +17,43 @@ import (
 
 func main() {
 	// Parse command line flags
-	configPath := flag.String("config", "config.json", "Path to configuration file")
+	configPath := flag.String("config", "config.json", "Path to configuration file (JSON, YAML or TOML)")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration, secrets redacted, and exit")
 	flag.Parse()
 
+	// The default config file is optional; one named with -config is not
+	path := *configPath
+	if !flagSet("config") {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			path = ""
+		}
+	}
+
 	// Load configuration
-	cfg, err := config.LoadConfig(*configPath)
+	cfg, err := config.LoadConfig(path)
 	if err != nil {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
+	if *printConfig {
+		fmt.Println(cfg)
+		if err := cfg.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if path != "" {
+		log.Printf("Loaded configuration from %s", path)
+	}
+	// Wallet and signer commands don't need the database
+	switch flag.Arg(0) {
+	case "wallet":
+		os.Exit(runWalletCommand(cfg, flag.Args()[1:]))
+	case "signer":
+		os.Exit(runSignerCommand(cfg, flag.Args()[1:]))
+	}
+
+	log.Printf("Effective configuration:\n%s", cfg)
 
 	// Initialize database
 	database, err := db.InitDB(cfg.Database.Path)
@@ -31,17 +62,54 @@ func main() {
 	}
 	defer database.Close()
 
+	// Run an admin command instead of the server
+	if flag.NArg() > 0 {
+		var code int
+		switch flag.Arg(0) {
+		case "apikey":
+			code = runAPIKeyCommand(database, flag.Args()[1:])
+		default:
+			fmt.Fprintf(os.Stderr, "Unknown command %q\n", flag.Arg(0))
+			code = 2
+		}
+		database.Close()
+		os.Exit(code)
+	}
+
+	// Refuse to start with a configuration that has any problem
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Error validating configuration: %v", err)
+	}
+	if err := cfg.CheckGenesis(); err != nil {
+		log.Fatalf("Error validating configuration: %v", err)
+	}
+
 	// Set up API server
 	server := api.NewServer(cfg, database)
+	server.EnableReload(func() (*config.Config, error) {
+		return config.LoadConfig(path)
+	})
 
 	// Start the server in a goroutine
 	go func() {
 		log.Printf("Starting server on %s", cfg.Server.Address)
-		if err := server.Start(); err != nil {
+		if err := server.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("Error starting server: %v", err)
 		}
 	}()
 
+	// Reload the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			log.Println("Reloading configuration...")
+			if _, err := server.Reload(); err != nil {
+				log.Printf("Error reloading configuration, keeping the running one: %v", err)
+			}
+		}
+	}()
+
 	// Set up graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -54,3 +122,14 @@ func main() {
 
 	fmt.Println("Server stopped")
 }
+
+// flagSet reports whether a flag was given on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// APIKey is a credential that lets CI and partner teams claim without a
+// captcha, within limits of its own. Only a hash of the key is stored.
+type APIKey struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`     // first characters of the key, to tell keys apart
+	DailyQuota int        `json:"dailyQuota"` // claims per 24 hours, 0 for unlimited
+	Amount     float64    `json:"amount"`     // SOL per claim, 0 for the faucet default
+	Networks   []string   `json:"networks"`   // networks the key may be used on, all when empty
+	Assets     []string   `json:"assets"`     // asset symbols the key may claim, all when empty
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Allows reports whether value is permitted by an allow list, where an empty
+// list permits everything. Entries match regardless of case, since asset
+// symbols and network names may be typed either way.
+func Allows(list []string, value string) bool {
+	if len(list) == 0 {
+		return true
+	}
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+// IdempotencyKey ties a client-supplied Idempotency-Key to the claim it
+// created, so retries of the same request get the same claim back
+type IdempotencyKey struct {
+	Key           string    `json:"key"`
+	WalletAddress string    `json:"walletAddress"`
+	Asset         string    `json:"asset"`
+	TransactionID int64     `json:"transactionId"` // 0 while the original request is in flight
+	CreatedAt     time.Time `json:"createdAt"`
+}
@@ -4,4 +4,18 @@ package models
 type FundRequest struct {
 	WalletAddress     string `json:"wallet_address"`
 	TurnstileResponse string `json:"cf_turnstile_response"`
+	CaptchaResponse   string `json:"captcha_response,omitempty"` // any provider's token
+	Asset             string `json:"asset,omitempty"`            // token symbol or mint address, SOL if empty
+
+	// Proof-of-work solution, accepted in place of a captcha when enabled
+	PowChallenge string `json:"pow_challenge,omitempty"`
+	PowNonce     string `json:"pow_nonce,omitempty"`
+}
+
+// CaptchaToken returns the captcha token, whichever field it was sent in
+func (r *FundRequest) CaptchaToken() string {
+	if r.CaptchaResponse != "" {
+		return r.CaptchaResponse
+	}
+	return r.TurnstileResponse
 }
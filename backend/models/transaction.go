@@ -9,17 +9,27 @@ type Transaction struct {
 	ID            int64     `json:"id"`
 	WalletAddress string    `json:"walletAddress"`
 	IPAddress     string    `json:"ipAddress,omitempty"` // omitted in JSON responses
+	Asset         string    `json:"asset"`               // "SOL" or a configured token symbol
 	Amount        float64   `json:"amount"`
-	Status        string    `json:"status"` // "pending", "completed", "failed"
+	Status        string    `json:"status"` // "queued", "sending", "pending", "completed", "failed"
 	TxHash        string    `json:"txHash,omitempty"`
 	ErrorMessage  string    `json:"errorMessage,omitempty"`
+	FeeLamports   uint64    `json:"feeLamports,omitempty"` // network fee paid, including priority fee
+	APIKeyID      int64     `json:"apiKeyId,omitempty"`    // API key the claim was made with, if any
+	Identity      string    `json:"-"`                     // signed-in identity, e.g. "github:1234", if any
+	Subnet        string    `json:"-"`                     // client subnet anonymous claims are limited by, e.g. "203.0.113.0/24"
 	Timestamp     time.Time `json:"timestamp"`
+
+	// LastValidBlockHeight is the block height after which a pending
+	// transaction's blockhash has expired and it can no longer land
+	LastValidBlockHeight uint64 `json:"-"`
 }
 
 // ClaimHistory represents a user's claim history
 type ClaimHistory struct {
 	ID            int64     `json:"id"`
 	WalletAddress string    `json:"walletAddress"`
+	Asset         string    `json:"asset"`
 	IPAddress     string    `json:"ipAddress,omitempty"` // omitted in JSON responses
 	LastClaimTime time.Time `json:"lastClaimTime"`
 	ClaimCount    int       `json:"claimCount"`
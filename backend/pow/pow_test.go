@@ -0,0 +1,107 @@
+package pow
+
+import (
+	"crypto/sha256"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// solve finds a nonce meeting the difficulty, or with fail set one that
+// doesn't
+func solve(challenge string, difficulty int, fail bool) string {
+	for n := 0; ; n++ {
+		nonce := strconv.Itoa(n)
+		ok := leadingZeroBits(sha256.Sum256([]byte(challenge+":"+nonce))) >= difficulty
+		if ok != fail {
+			return nonce
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const wallet = "wallet-1"
+	issuer, err := NewIssuer([]byte("secret"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewIssuer([]byte("other secret"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := NewIssuer([]byte("secret"), time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		issuer *Issuer
+		wallet string
+		tamper bool
+		fail   bool
+		want   error
+	}{
+		{name: "valid", issuer: issuer, wallet: wallet},
+		{name: "wrong wallet", issuer: issuer, wallet: "wallet-2", want: ErrWrongWallet},
+		{name: "insufficient work", issuer: issuer, wallet: wallet, fail: true, want: ErrInsufficientWork},
+		{name: "tampered", issuer: issuer, wallet: wallet, tamper: true, want: ErrInvalidChallenge},
+		{name: "other secret", issuer: other, wallet: wallet, want: ErrInvalidChallenge},
+		{name: "expired", issuer: expired, wallet: wallet, want: ErrExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := tt.issuer.Issue(wallet, 8)
+			if err != nil {
+				t.Fatal(err)
+			}
+			challenge := c.Challenge
+			if tt.tamper {
+				challenge = "x" + challenge
+			}
+			err = issuer.Verify(challenge, tt.wallet, solve(challenge, c.Difficulty, tt.fail))
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyOnlyOnce(t *testing.T) {
+	issuer, err := NewIssuer([]byte("secret"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := issuer.Issue("wallet-1", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := solve(c.Challenge, c.Difficulty, false)
+
+	if err := issuer.Verify(c.Challenge, "wallet-1", nonce); err != nil {
+		t.Fatalf("first Verify = %v", err)
+	}
+	if err := issuer.Verify(c.Challenge, "wallet-1", nonce); !errors.Is(err, ErrAlreadyUsed) {
+		t.Fatalf("second Verify = %v, want %v", err, ErrAlreadyUsed)
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	tests := []struct {
+		prefix []byte
+		want   int
+	}{
+		{[]byte{0x80}, 0},
+		{[]byte{0x01}, 7},
+		{[]byte{0x00, 0xff}, 8},
+		{[]byte{0x00, 0x00, 0x10}, 19},
+	}
+	for _, tt := range tests {
+		var hash [sha256.Size]byte
+		copy(hash[:], tt.prefix)
+		if got := leadingZeroBits(hash); got != tt.want {
+			t.Errorf("leadingZeroBits(%x...) = %d, want %d", tt.prefix, got, tt.want)
+		}
+	}
+}
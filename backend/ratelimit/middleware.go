@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// KeyFunc identifies the client a request should be counted against
+type KeyFunc func(r *http.Request) string
+
+// Limiter is a fixed-window rate limiter keyed by client and route
+type Limiter struct {
+	store   Store
+	keyFunc KeyFunc
+
+	mu       sync.RWMutex // guards requests and window, which can be reloaded
+	requests int
+	window   time.Duration
+}
+
+// NewLimiter creates a limiter allowing requests per window for each client
+// and route. A requests value of zero or less disables limiting.
+func NewLimiter(store Store, requests int, window time.Duration, keyFunc KeyFunc) *Limiter {
+	return &Limiter{
+		store:    store,
+		keyFunc:  keyFunc,
+		requests: requests,
+		window:   window,
+	}
+}
+
+// SetLimit changes the number of requests allowed per window. Counts already
+// in the store carry over.
+func (l *Limiter) SetLimit(requests int, window time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests = requests
+	l.window = window
+}
+
+// limit returns the current requests and window
+func (l *Limiter) limit() (int, time.Duration) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.requests, l.window
+}
+
+// Handler returns the chi middleware enforcing the limit. It sets the
+// RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers on every
+// response and Retry-After when the limit is exceeded.
+func (l *Limiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests, window := l.limit()
+		if requests <= 0 || window <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := l.key(r)
+		count, reset, err := l.store.Increment(key, window)
+		if err != nil {
+			// Fail open: a broken store shouldn't take the faucet down
+			log.Printf("[RateLimit] Store error for %s: %v", key, err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		remaining := requests - count
+		if remaining < 0 {
+			remaining = 0
+		}
+		resetSeconds := int(math.Ceil(time.Until(reset).Seconds()))
+		if resetSeconds < 0 {
+			resetSeconds = 0
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(requests))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(resetSeconds))
+
+		if count > requests {
+			log.Printf("[RateLimit] Limit exceeded for %s", key)
+			w.Header().Set("Retry-After", strconv.Itoa(resetSeconds))
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": false,
+				"error":   "Too many requests, please try again later",
+			})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Allow charges one more hit to the request's client and route, for
+// requests that carry several operations such as a JSON-RPC batch or that
+// are only limited for some of them, and reports whether it is within the
+// limit
+func (l *Limiter) Allow(r *http.Request) bool {
+	requests, window := l.limit()
+	if requests <= 0 || window <= 0 {
+		return true
+	}
+
+	key := l.key(r)
+	count, _, err := l.store.Increment(key, window)
+	if err != nil {
+		log.Printf("[RateLimit] Store error for %s: %v", key, err)
+		return true
+	}
+	if count > requests {
+		log.Printf("[RateLimit] Limit exceeded for %s", key)
+		return false
+	}
+	return true
+}
+
+// key identifies the client and route a request is counted against
+func (l *Limiter) key(r *http.Request) string {
+	return l.keyFunc(r) + "|" + r.Method + " " + routePattern(r)
+}
+
+// routePattern returns the matched chi route pattern, falling back to the path
+func routePattern(r *http.Request) string {
+	if rctx := chi.RouteContext(r.Context()); rctx != nil {
+		if pattern := rctx.RoutePattern(); pattern != "" {
+			return pattern
+		}
+	}
+	return r.URL.Path
+}
@@ -0,0 +1,129 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// request is one request of a test sequence and its expected status
+type request struct {
+	path   string
+	client string
+	want   int
+}
+
+// newRouter serves /a and /b/{id} behind the limiter. Like the server it
+// applies the limiter in a group, so it runs once the route is matched.
+func newRouter(l *Limiter) http.Handler {
+	r := chi.NewRouter()
+	r.Group(func(r chi.Router) {
+		r.Use(l.Handler)
+		ok := func(w http.ResponseWriter, r *http.Request) {}
+		r.Get("/a", ok)
+		r.Get("/b/{id}", ok)
+	})
+	return r
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		requests []request
+	}{
+		{"under the limit", 2, []request{
+			{"/a", "client-1", http.StatusOK},
+			{"/a", "client-1", http.StatusOK},
+		}},
+		{"over the limit", 2, []request{
+			{"/a", "client-1", http.StatusOK},
+			{"/a", "client-1", http.StatusOK},
+			{"/a", "client-1", http.StatusTooManyRequests},
+		}},
+		{"per client", 1, []request{
+			{"/a", "client-1", http.StatusOK},
+			{"/a", "client-2", http.StatusOK},
+			{"/a", "client-1", http.StatusTooManyRequests},
+		}},
+		{"per route", 1, []request{
+			{"/a", "client-1", http.StatusOK},
+			{"/b/1", "client-1", http.StatusOK},
+		}},
+		{"by route pattern", 1, []request{
+			{"/b/1", "client-1", http.StatusOK},
+			{"/b/2", "client-1", http.StatusTooManyRequests},
+		}},
+		{"disabled", 0, []request{
+			{"/a", "client-1", http.StatusOK},
+			{"/a", "client-1", http.StatusOK},
+			{"/a", "client-1", http.StatusOK},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(NewMemoryStore(), tt.limit, time.Minute, func(r *http.Request) string { return r.RemoteAddr })
+			router := newRouter(l)
+			for i, req := range tt.requests {
+				r := httptest.NewRequest(http.MethodGet, req.path, nil)
+				r.RemoteAddr = req.client
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r)
+				if w.Code != req.want {
+					t.Fatalf("request %d to %s = %d, want %d", i, req.path, w.Code, req.want)
+				}
+				if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") == "" {
+					t.Fatalf("request %d was limited without Retry-After", i)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowSharesTheRouteCount(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), 3, time.Minute, func(r *http.Request) string { return r.RemoteAddr })
+	r := chi.NewRouter()
+	var allowed []bool
+	r.With(l.Handler).Post("/rpc", func(w http.ResponseWriter, r *http.Request) {
+		// A batch of three charges two more hits
+		allowed = append(allowed, l.Allow(r), l.Allow(r))
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/rpc", nil)
+	req.RemoteAddr = "client-1"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if len(allowed) != 2 || !allowed[0] || !allowed[1] {
+		t.Fatalf("Allow within the limit = %v, want [true true]", allowed)
+	}
+
+	allowed = nil
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request after the batch = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestSetLimit(t *testing.T) {
+	l := NewLimiter(NewMemoryStore(), 1, time.Minute, func(r *http.Request) string { return r.RemoteAddr })
+	router := newRouter(l)
+	serve := func() int {
+		r := httptest.NewRequest(http.MethodGet, "/a", nil)
+		r.RemoteAddr = "client-1"
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	serve()
+	if code := serve(); code != http.StatusTooManyRequests {
+		t.Fatalf("second request = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	l.SetLimit(3, time.Minute)
+	if code := serve(); code != http.StatusOK {
+		t.Fatalf("request after raising the limit = %d, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Store keeps the hit counters for the rate limiter. Implementations must be
+// safe for concurrent use.
+type Store interface {
+	// Increment records a hit for key in the current fixed window of the given
+	// length and returns the number of hits in that window and when it resets
+	Increment(key string, window time.Duration) (count int, reset time.Time, err error)
+}
+
+// counter is a single fixed window in the memory store
+type counter struct {
+	count int
+	reset time.Time
+}
+
+// MemoryStore is an in-process Store. Counters are lost on restart.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+}
+
+// sweepInterval is how often expired counters are removed from memory
+const sweepInterval = time.Minute
+
+// NewMemoryStore creates a new in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters:  make(map[string]*counter),
+		lastSweep: time.Now(),
+	}
+}
+
+// Increment implements Store
+func (m *MemoryStore) Increment(key string, window time.Duration) (int, time.Time, error) {
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Drop expired windows so the map doesn't grow with every client ever seen
+	if now.Sub(m.lastSweep) > sweepInterval {
+		for k, c := range m.counters {
+			if !now.Before(c.reset) {
+				delete(m.counters, k)
+			}
+		}
+		m.lastSweep = now
+	}
+
+	c, ok := m.counters[key]
+	if !ok || !now.Before(c.reset) {
+		c = &counter{reset: now.Add(window)}
+		m.counters[key] = c
+	}
+	c.count++
+
+	return c.count, c.reset, nil
+}
@@ -0,0 +1,103 @@
+package signer
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// maxSignRequestSize bounds sign requests. Transactions are at most 1232
+// bytes, so this leaves ample room for the base64 message.
+const maxSignRequestSize = 8 << 10
+
+// signRequest is the body of POST /v1/sign
+type signRequest struct {
+	Message string `json:"message"` // base64 serialized transaction message
+}
+
+// NewHandler serves a signer to remote clients. Requests must carry the
+// token as a bearer token unless it is empty.
+func NewHandler(s Signer, token string) http.Handler {
+	r := chi.NewRouter()
+	r.Use(middleware.Recoverer)
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token != "" && subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "Invalid signer token")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	r.Get("/v1/public-key", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":   true,
+			"publicKey": s.PublicKey().String(),
+		})
+	})
+
+	r.Post("/v1/sign", func(w http.ResponseWriter, r *http.Request) {
+		var req signRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSignRequestSize)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		message, err := base64.StdEncoding.DecodeString(req.Message)
+		if err != nil || len(message) == 0 {
+			writeError(w, http.StatusBadRequest, "Message must be base64")
+			return
+		}
+
+		sig, err := s.Sign(r.Context(), message)
+		if errors.Is(err, ErrPolicyViolation) {
+			log.Printf("[Signer] Refused to sign: %v", err)
+			writeError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("[Signer] Error signing: %v", err)
+			writeError(w, http.StatusInternalServerError, "Failed to sign message")
+			return
+		}
+
+		log.Printf("[Signer] Signed %s", sig)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":   true,
+			"signature": sig.String(),
+		})
+	})
+
+	return r
+}
+
+// bearerToken returns the request's bearer token, if any
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(auth, "Bearer ")
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes the standard {"success": false, "error": ...} response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"success": false,
+		"error":   message,
+	})
+}
@@ -0,0 +1,328 @@
+package signer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+	computebudget "github.com/gagliardetto/solana-go/programs/compute-budget"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/programs/token"
+)
+
+// Base transaction fee and the compute unit limit the runtime assumes per
+// instruction when a transaction doesn't set one
+const (
+	lamportsPerSignature       = 5000
+	defaultInstructionCULimit  = 200_000
+	maxTransactionComputeUnits = 1_400_000
+)
+
+// System program instructions that move lamports out of the funding account
+const (
+	systemCreateAccount         = 0
+	systemTransfer              = 2
+	systemCreateAccountWithSeed = 3
+	systemTransferWithSeed      = 11
+)
+
+// Compute budget program instructions
+const (
+	computeBudgetSetLimit = 2
+	computeBudgetSetPrice = 3
+)
+
+// The only token and associated token account instructions faucet
+// transfers use
+const (
+	tokenTransferChecked = 12
+	ataCreateIdempotent  = 1
+)
+
+// DefaultAllowedPrograms are the programs faucet transfers invoke. Token and
+// associated token account instructions are further limited to
+// TransferChecked and CreateIdempotent.
+var DefaultAllowedPrograms = []solana.PublicKey{
+	system.ProgramID,
+	computebudget.ProgramID,
+	token.ProgramID,
+	solana.Token2022ProgramID,
+	solana.SPLAssociatedTokenAccountProgramID,
+}
+
+// PolicyOptions configures a spending policy
+type PolicyOptions struct {
+	MaxLamportsPerTx   uint64             // lamports a single transaction may spend, 0 for no limit
+	MaxLamportsPerHour uint64             // lamports all transactions may spend per rolling hour, 0 for no limit
+	AllowedPrograms    []solana.PublicKey // programs a transaction may invoke, DefaultAllowedPrograms when empty
+
+	// MaxTokensPerTx is how many whole tokens of each mint a single
+	// transaction may send. Mints that aren't listed can't be sent.
+	MaxTokensPerTx map[solana.PublicKey]float64
+}
+
+// Policy limits what a signer signs. A transaction's spend is the lamports
+// its system program instructions move plus the fees it commits to; token
+// transfers are capped per mint and transaction instead. Account rent isn't
+// counted.
+type Policy struct {
+	rules atomic.Pointer[policyRules]
+
+	mutex  sync.Mutex
+	spends []*Spend
+}
+
+// policyRules are the limits a policy currently enforces
+type policyRules struct {
+	opts    PolicyOptions
+	allowed map[solana.PublicKey]bool
+}
+
+// Spend is a signed transaction's share of the hourly allowance
+type Spend struct {
+	policy   *Policy
+	at       time.Time
+	lamports uint64
+}
+
+// NewPolicy creates a spending policy
+func NewPolicy(opts PolicyOptions) *Policy {
+	p := &Policy{}
+	p.Update(opts)
+	return p
+}
+
+// Update replaces the policy's limits, for example after the amounts or
+// tokens they're derived from were reloaded. Spends already reserved keep
+// counting against the hourly allowance.
+func (p *Policy) Update(opts PolicyOptions) {
+	programs := opts.AllowedPrograms
+	if len(programs) == 0 {
+		programs = DefaultAllowedPrograms
+	}
+	allowed := make(map[solana.PublicKey]bool, len(programs))
+	for _, program := range programs {
+		allowed[program] = true
+	}
+	p.rules.Store(&policyRules{opts: opts, allowed: allowed})
+}
+
+// Check decodes a message, checks it against the policy and reserves its
+// spend against the hourly allowance. A transaction re-signed with a new
+// blockhash counts again, so the hourly limit errs on the side of caution.
+func (p *Policy) Check(payer solana.PublicKey, message []byte) (*Spend, error) {
+	var msg solana.Message
+	if err := msg.UnmarshalWithDecoder(bin.NewBinDecoder(message)); err != nil {
+		return nil, fmt.Errorf("%w: can't decode message: %v", ErrPolicyViolation, err)
+	}
+
+	rules := p.rules.Load()
+	lamports, err := rules.inspect(payer, &msg)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPolicyViolation, err)
+	}
+	if rules.opts.MaxLamportsPerTx > 0 && lamports > rules.opts.MaxLamportsPerTx {
+		return nil, fmt.Errorf("%w: transaction spends %d lamports, over the %d per transaction limit",
+			ErrPolicyViolation, lamports, rules.opts.MaxLamportsPerTx)
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	p.prune(now)
+	if rules.opts.MaxLamportsPerHour > 0 {
+		var spent uint64
+		for _, s := range p.spends {
+			spent += s.lamports
+		}
+		if spent+lamports > rules.opts.MaxLamportsPerHour {
+			return nil, fmt.Errorf("%w: transaction spends %d lamports with %d already spent this hour, over the %d hourly limit",
+				ErrPolicyViolation, lamports, spent, rules.opts.MaxLamportsPerHour)
+		}
+	}
+
+	spend := &Spend{policy: p, at: now, lamports: lamports}
+	p.spends = append(p.spends, spend)
+	return spend, nil
+}
+
+// Cancel returns a spend to the hourly allowance, for messages that ended
+// up not being signed
+func (s *Spend) Cancel() {
+	p := s.policy
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for i, other := range p.spends {
+		if other == s {
+			p.spends = append(p.spends[:i], p.spends[i+1:]...)
+			return
+		}
+	}
+}
+
+// prune drops spends older than an hour. Callers must hold the mutex.
+func (p *Policy) prune(now time.Time) {
+	cutoff := now.Add(-time.Hour)
+	i := 0
+	for i < len(p.spends) && p.spends[i].at.Before(cutoff) {
+		i++
+	}
+	p.spends = p.spends[i:]
+}
+
+// inspect checks the message's programs and signers and returns the
+// lamports it spends
+func (r *policyRules) inspect(payer solana.PublicKey, msg *solana.Message) (uint64, error) {
+	// Address lookup tables could hide the programs being invoked
+	if msg.NumLookups() > 0 {
+		return 0, fmt.Errorf("address lookup tables aren't allowed")
+	}
+
+	numSigners := int(msg.Header.NumRequiredSignatures)
+	if numSigners == 0 || len(msg.AccountKeys) == 0 || !msg.AccountKeys[0].Equals(payer) {
+		return 0, fmt.Errorf("fee payer must be %s", payer)
+	}
+
+	var transferred uint64
+	var unitPrice uint64
+	var unitLimit uint64
+	var limitSet bool
+	var counted uint64
+	tokens := map[solana.PublicKey]*tokenSpend{}
+
+	for i, inst := range msg.Instructions {
+		if int(inst.ProgramIDIndex) >= len(msg.AccountKeys) {
+			return 0, fmt.Errorf("instruction %d has an invalid program index", i)
+		}
+		program := msg.AccountKeys[inst.ProgramIDIndex]
+		if !r.allowed[program] {
+			return 0, fmt.Errorf("instruction %d invokes %s, which isn't allowed", i, program)
+		}
+
+		data := []byte(inst.Data)
+		switch program {
+		case system.ProgramID:
+			lamports, err := systemLamports(data)
+			if err != nil {
+				return 0, fmt.Errorf("instruction %d: %v", i, err)
+			}
+			transferred += lamports
+		case token.ProgramID, solana.Token2022ProgramID:
+			if err := addTokenTransfer(tokens, msg, inst); err != nil {
+				return 0, fmt.Errorf("instruction %d: %v", i, err)
+			}
+		case solana.SPLAssociatedTokenAccountProgramID:
+			if len(data) != 1 || data[0] != ataCreateIdempotent {
+				return 0, fmt.Errorf("instruction %d: only CreateIdempotent is allowed on the associated token account program", i)
+			}
+		case computebudget.ProgramID:
+			switch {
+			case len(data) == 5 && data[0] == computeBudgetSetLimit:
+				unitLimit = uint64(binary.LittleEndian.Uint32(data[1:]))
+				limitSet = true
+			case len(data) == 9 && data[0] == computeBudgetSetPrice:
+				unitPrice = binary.LittleEndian.Uint64(data[1:])
+			}
+			continue
+		}
+		counted++
+	}
+
+	for mint, spend := range tokens {
+		max, ok := r.opts.MaxTokensPerTx[mint]
+		if !ok {
+			return 0, fmt.Errorf("transfers of mint %s aren't allowed", mint)
+		}
+		if amount := float64(spend.amount) / math.Pow10(int(spend.decimals)); amount > max {
+			return 0, fmt.Errorf("transaction sends %g of mint %s, over the %g per transaction limit", amount, mint, max)
+		}
+	}
+
+	// Without an explicit limit the runtime allows 200k units per instruction
+	if !limitSet {
+		unitLimit = counted * defaultInstructionCULimit
+		if unitLimit > maxTransactionComputeUnits {
+			unitLimit = maxTransactionComputeUnits
+		}
+	}
+	priorityFee := (unitPrice*unitLimit + 999_999) / 1_000_000
+	fees := uint64(numSigners)*lamportsPerSignature + priorityFee
+
+	return transferred + fees, nil
+}
+
+// tokenSpend is what a transaction sends of one mint
+type tokenSpend struct {
+	amount   uint64
+	decimals uint8
+}
+
+// addTokenTransfer adds a token program instruction's transfer to tokens.
+// Only TransferChecked is allowed: it names the mint and its decimals, which
+// the token program verifies.
+func addTokenTransfer(tokens map[solana.PublicKey]*tokenSpend, msg *solana.Message, inst solana.CompiledInstruction) error {
+	data := []byte(inst.Data)
+	if len(data) == 0 || data[0] != tokenTransferChecked {
+		return fmt.Errorf("only TransferChecked is allowed on the token programs")
+	}
+	// u8 tag, u64 amount, u8 decimals; accounts are source, mint,
+	// destination and owner
+	if len(data) != 10 || len(inst.Accounts) < 4 || int(inst.Accounts[1]) >= len(msg.AccountKeys) {
+		return fmt.Errorf("malformed TransferChecked instruction")
+	}
+	mint := msg.AccountKeys[inst.Accounts[1]]
+	amount := binary.LittleEndian.Uint64(data[1:9])
+	decimals := data[9]
+
+	spend, ok := tokens[mint]
+	if !ok {
+		spend = &tokenSpend{decimals: decimals}
+		tokens[mint] = spend
+	}
+	if spend.decimals != decimals || spend.amount+amount < spend.amount {
+		return fmt.Errorf("inconsistent TransferChecked instructions for mint %s", mint)
+	}
+	spend.amount += amount
+	return nil
+}
+
+// systemLamports returns the lamports a system program instruction moves
+func systemLamports(data []byte) (uint64, error) {
+	if len(data) < 4 {
+		return 0, fmt.Errorf("truncated system instruction")
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case systemCreateAccount, systemTransfer:
+		// u32 tag, u64 lamports
+		if len(data) < 12 {
+			return 0, fmt.Errorf("truncated system instruction")
+		}
+		return binary.LittleEndian.Uint64(data[4:12]), nil
+	case systemTransferWithSeed:
+		// u32 tag, u64 lamports, seed, owner
+		if len(data) < 12 {
+			return 0, fmt.Errorf("truncated system instruction")
+		}
+		return binary.LittleEndian.Uint64(data[4:12]), nil
+	case systemCreateAccountWithSeed:
+		// u32 tag, base pubkey, u64-length-prefixed seed, u64 lamports
+		if len(data) < 4+32+8 {
+			return 0, fmt.Errorf("truncated system instruction")
+		}
+		seedLen := binary.LittleEndian.Uint64(data[36:44])
+		offset := uint64(44) + seedLen
+		if seedLen > 32 || uint64(len(data)) < offset+8 {
+			return 0, fmt.Errorf("truncated system instruction")
+		}
+		return binary.LittleEndian.Uint64(data[offset : offset+8]), nil
+	default:
+		return 0, fmt.Errorf("system instruction %d isn't allowed", binary.LittleEndian.Uint32(data))
+	}
+}
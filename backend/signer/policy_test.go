@@ -0,0 +1,177 @@
+package signer
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+	computebudget "github.com/gagliardetto/solana-go/programs/compute-budget"
+	"github.com/gagliardetto/solana-go/programs/system"
+)
+
+// Fixed keys so failures are reproducible
+var (
+	payer     = solana.MustPublicKeyFromBase58("9WzDXwBbmkg8ZTbNMqUxvQRAyrZzDsGYdLVL9zYtAWWM")
+	recipient = solana.MustPublicKeyFromBase58("HAgk14JpMQLgt6rVgv7cBQFJWFto5Dqxi472uT3DKpqk")
+	mint      = solana.MustPublicKeyFromBase58("Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB")
+	otherMint = solana.MustPublicKeyFromBase58("EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v")
+)
+
+// message compiles instructions into a message paid for by feePayer
+func message(t *testing.T, feePayer solana.PublicKey, instructions ...solana.Instruction) []byte {
+	t.Helper()
+	tx, err := solana.NewTransaction(instructions, solana.Hash{}, solana.TransactionPayer(feePayer))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tx.Message.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func transfer(lamports uint64) solana.Instruction {
+	return system.NewTransferInstruction(lamports, payer, recipient).Build()
+}
+
+// tokenInstruction builds a token program instruction with the
+// TransferChecked layout and the given tag
+func tokenInstruction(tag byte, tokenMint solana.PublicKey, amount uint64, decimals uint8) solana.Instruction {
+	data := make([]byte, 10)
+	data[0] = tag
+	binary.LittleEndian.PutUint64(data[1:9], amount)
+	data[9] = decimals
+	return solana.NewInstruction(
+		solana.TokenProgramID,
+		solana.AccountMetaSlice{
+			solana.Meta(payer).WRITE(),
+			solana.Meta(tokenMint),
+			solana.Meta(recipient).WRITE(),
+			solana.Meta(payer).SIGNER(),
+		},
+		data,
+	)
+}
+
+func ataInstruction(data []byte) solana.Instruction {
+	return solana.NewInstruction(
+		solana.SPLAssociatedTokenAccountProgramID,
+		solana.AccountMetaSlice{
+			solana.Meta(payer).WRITE().SIGNER(),
+			solana.Meta(recipient).WRITE(),
+		},
+		data,
+	)
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := NewPolicy(PolicyOptions{
+		MaxLamportsPerTx: 1_000_000,
+		MaxTokensPerTx:   map[solana.PublicKey]float64{mint: 100},
+	})
+
+	tests := []struct {
+		name      string
+		feePayer  solana.PublicKey
+		ins       []solana.Instruction
+		violation bool
+	}{
+		{name: "transfer within the limit", ins: []solana.Instruction{transfer(900_000)}},
+		{name: "transfer over the limit", ins: []solana.Instruction{transfer(1_000_000)}, violation: true},
+		{name: "transfers add up", ins: []solana.Instruction{transfer(600_000), transfer(600_000)}, violation: true},
+		{name: "priority fee counts", ins: []solana.Instruction{
+			computebudget.NewSetComputeUnitLimitInstruction(200_000).Build(),
+			computebudget.NewSetComputeUnitPriceInstruction(1_000_000).Build(),
+			transfer(900_000),
+		}, violation: true},
+		{name: "other fee payer", feePayer: recipient, ins: []solana.Instruction{transfer(1)}, violation: true},
+		{name: "other system instruction", ins: []solana.Instruction{
+			system.NewAssignInstruction(solana.TokenProgramID, payer).Build(),
+		}, violation: true},
+		{name: "program not allowed", ins: []solana.Instruction{
+			solana.NewInstruction(solana.MemoProgramID, solana.AccountMetaSlice{solana.Meta(payer).SIGNER()}, []byte("hi")),
+		}, violation: true},
+		{name: "token transfer within the cap", ins: []solana.Instruction{
+			ataInstruction([]byte{1}),
+			tokenInstruction(12, mint, 100_000_000, 6),
+		}},
+		{name: "token transfer over the cap", ins: []solana.Instruction{
+			tokenInstruction(12, mint, 100_000_001, 6),
+		}, violation: true},
+		{name: "token transfers add up", ins: []solana.Instruction{
+			tokenInstruction(12, mint, 60_000_000, 6),
+			tokenInstruction(12, mint, 60_000_000, 6),
+		}, violation: true},
+		{name: "mint without a cap", ins: []solana.Instruction{
+			tokenInstruction(12, otherMint, 1, 6),
+		}, violation: true},
+		{name: "unchecked token transfer", ins: []solana.Instruction{
+			tokenInstruction(3, mint, 1, 6),
+		}, violation: true},
+		{name: "token approve", ins: []solana.Instruction{
+			tokenInstruction(4, mint, 1, 6),
+		}, violation: true},
+		{name: "non-idempotent account creation", ins: []solana.Instruction{
+			ataInstruction([]byte{}),
+		}, violation: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			feePayer := tt.feePayer
+			if feePayer.IsZero() {
+				feePayer = payer
+			}
+			spend, err := policy.Check(payer, message(t, feePayer, tt.ins...))
+			if tt.violation {
+				if !errors.Is(err, ErrPolicyViolation) {
+					t.Fatalf("Check = %v, want a policy violation", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Check = %v", err)
+			}
+			spend.Cancel()
+		})
+	}
+}
+
+func TestPolicyHourlyLimit(t *testing.T) {
+	// Each transfer spends 400k lamports plus the 5000 lamport signature fee
+	policy := NewPolicy(PolicyOptions{MaxLamportsPerHour: 1_000_000})
+	msg := message(t, payer, transfer(400_000))
+
+	first, err := policy.Check(payer, msg)
+	if err != nil {
+		t.Fatalf("first Check = %v", err)
+	}
+	if _, err := policy.Check(payer, msg); err != nil {
+		t.Fatalf("second Check = %v", err)
+	}
+	if _, err := policy.Check(payer, msg); !errors.Is(err, ErrPolicyViolation) {
+		t.Fatalf("third Check = %v, want a policy violation", err)
+	}
+
+	// A cancelled spend goes back to the allowance
+	first.Cancel()
+	if _, err := policy.Check(payer, msg); err != nil {
+		t.Fatalf("Check after Cancel = %v", err)
+	}
+}
+
+func TestPolicyUpdate(t *testing.T) {
+	policy := NewPolicy(PolicyOptions{MaxTokensPerTx: map[solana.PublicKey]float64{mint: 100}})
+	msg := message(t, payer, tokenInstruction(12, otherMint, 1_000_000, 6))
+
+	if _, err := policy.Check(payer, msg); !errors.Is(err, ErrPolicyViolation) {
+		t.Fatalf("Check before Update = %v, want a policy violation", err)
+	}
+
+	// A token added on reload can be sent once the policy is updated
+	policy.Update(PolicyOptions{MaxTokensPerTx: map[solana.PublicKey]float64{mint: 100, otherMint: 10}})
+	if _, err := policy.Check(payer, msg); err != nil {
+		t.Fatalf("Check after Update = %v", err)
+	}
+}
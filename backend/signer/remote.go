@@ -0,0 +1,124 @@
+package signer
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// RemoteOptions configures a remote signer
+type RemoteOptions struct {
+	URL     string        // base URL of the signing daemon
+	Token   string        // bearer token the daemon expects
+	Timeout time.Duration // per request, 10 seconds when zero
+}
+
+// Remote signs through a signing daemon, so this process never holds the
+// key. Every signature is verified against the daemon's public key.
+type Remote struct {
+	url       string
+	token     string
+	client    *http.Client
+	publicKey solana.PublicKey
+}
+
+// signerResponse is the daemon's response envelope
+type signerResponse struct {
+	Success   bool   `json:"success"`
+	Error     string `json:"error"`
+	PublicKey string `json:"publicKey"`
+	Signature string `json:"signature"`
+}
+
+// NewRemote connects to a signing daemon and fetches its public key
+func NewRemote(ctx context.Context, opts RemoteOptions) (*Remote, error) {
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	r := &Remote{
+		url:    strings.TrimRight(opts.URL, "/"),
+		token:  opts.Token,
+		client: &http.Client{Timeout: timeout},
+	}
+
+	var resp signerResponse
+	if err := r.do(ctx, http.MethodGet, "/v1/public-key", nil, &resp); err != nil {
+		return nil, fmt.Errorf("failed to get the signer's public key: %w", err)
+	}
+	publicKey, err := solana.PublicKeyFromBase58(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("signer returned an invalid public key: %w", err)
+	}
+	r.publicKey = publicKey
+
+	return r, nil
+}
+
+// PublicKey returns the daemon's wallet address
+func (r *Remote) PublicKey() solana.PublicKey {
+	return r.publicKey
+}
+
+// Sign asks the daemon to sign a message
+func (r *Remote) Sign(ctx context.Context, message []byte) (solana.Signature, error) {
+	body, err := json.Marshal(signRequest{Message: base64.StdEncoding.EncodeToString(message)})
+	if err != nil {
+		return solana.Signature{}, err
+	}
+
+	var resp signerResponse
+	if err := r.do(ctx, http.MethodPost, "/v1/sign", body, &resp); err != nil {
+		return solana.Signature{}, err
+	}
+
+	sig, err := solana.SignatureFromBase58(resp.Signature)
+	if err != nil {
+		return solana.Signature{}, fmt.Errorf("signer returned an invalid signature: %w", err)
+	}
+	if !sig.Verify(r.publicKey, message) {
+		return solana.Signature{}, errors.New("signer returned a signature that doesn't verify")
+	}
+	return sig, nil
+}
+
+// do sends a request to the daemon and decodes its response. Refusals by
+// the daemon's policy wrap ErrPolicyViolation.
+func (r *Remote) do(ctx context.Context, method, path string, body []byte, out *signerResponse) error {
+	req, err := http.NewRequestWithContext(ctx, method, r.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if r.token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.token)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("signer request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxSignRequestSize)).Decode(out); err != nil {
+		return fmt.Errorf("signer returned status %d with an unreadable body", resp.StatusCode)
+	}
+	switch {
+	case resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("%w: %s", ErrPolicyViolation, strings.TrimPrefix(out.Error, ErrPolicyViolation.Error()+": "))
+	case resp.StatusCode != http.StatusOK || !out.Success:
+		return fmt.Errorf("signer returned status %d: %s", resp.StatusCode, out.Error)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Package signer signs faucet transactions, either in process or through a
+// separate signing daemon so the API process never holds the wallet key,
+// and enforces a spending policy before anything is signed.
+package signer
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// ErrPolicyViolation is wrapped by errors for messages the spending policy
+// refused to sign. Retrying such a message won't help.
+var ErrPolicyViolation = errors.New("refused by signing policy")
+
+// Signer signs serialized transaction messages with the faucet wallet
+type Signer interface {
+	// PublicKey returns the wallet's address
+	PublicKey() solana.PublicKey
+
+	// Sign returns the wallet's signature over a serialized message
+	Sign(ctx context.Context, message []byte) (solana.Signature, error)
+}
+
+// Local signs with a key held in memory
+type Local struct {
+	key solana.PrivateKey
+}
+
+// NewLocal creates a signer for an in-memory key
+func NewLocal(key solana.PrivateKey) *Local {
+	return &Local{key: key}
+}
+
+// PublicKey returns the wallet's address
+func (l *Local) PublicKey() solana.PublicKey {
+	return l.key.PublicKey()
+}
+
+// Sign signs a message with the in-memory key
+func (l *Local) Sign(_ context.Context, message []byte) (solana.Signature, error) {
+	return l.key.Sign(message)
+}
+
+// Guarded checks every message against a spending policy before passing it
+// to the wrapped signer
+type Guarded struct {
+	next   Signer
+	policy *Policy
+}
+
+// WithPolicy wraps a signer so it only signs what the policy allows
+func WithPolicy(next Signer, policy *Policy) *Guarded {
+	return &Guarded{next: next, policy: policy}
+}
+
+// PublicKey returns the wrapped signer's address
+func (g *Guarded) PublicKey() solana.PublicKey {
+	return g.next.PublicKey()
+}
+
+// Sign checks a message against the policy, then signs it. The spend is
+// only recorded once the message is signed.
+func (g *Guarded) Sign(ctx context.Context, message []byte) (solana.Signature, error) {
+	spend, err := g.policy.Check(g.next.PublicKey(), message)
+	if err != nil {
+		return solana.Signature{}, err
+	}
+	sig, err := g.next.Sign(ctx, message)
+	if err != nil {
+		spend.Cancel()
+		return solana.Signature{}, err
+	}
+	return sig, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/maestroi/solana-faucet/backend/config"
+	"github.com/maestroi/solana-faucet/backend/signer"
+	"github.com/maestroi/solana-faucet/backend/wallet"
+)
+
+// signerUsage describes the signer subcommand
+const signerUsage = `Usage:
+  faucet signer serve`
+
+// runSignerCommand runs the signing daemon and returns the process exit
+// code
+func runSignerCommand(cfg *config.Config, args []string) int {
+	if len(args) != 1 || args[0] != "serve" {
+		fmt.Fprintln(os.Stderr, signerUsage)
+		return 2
+	}
+	return serveSigner(cfg)
+}
+
+// serveSigner holds the wallet and signs for API processes configured with
+// FAUCET_SIGNER_URL, within the spending policy, until interrupted
+func serveSigner(cfg *config.Config) int {
+	opts := cfg.WalletOptions()
+	key, err := wallet.Load(opts)
+	if err != nil {
+		log.Printf("Failed to load wallet from %s: %v", opts.Source(), err)
+		return 1
+	}
+	policy, err := cfg.SignerPolicy()
+	if err != nil {
+		log.Printf("Invalid signer policy: %v", err)
+		return 1
+	}
+	if cfg.Signer.Token == "" {
+		log.Println("WARNING: FAUCET_SIGNER_TOKEN is not set, anyone who can reach the signer can spend within its policy")
+	}
+
+	guarded := signer.WithPolicy(signer.NewLocal(key), signer.NewPolicy(policy))
+	server := &http.Server{
+		Addr:              cfg.Signer.Listen,
+		Handler:           signer.NewHandler(guarded, cfg.Signer.Token),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("[Signer] Signing as %s on %s (max %d lamports per transaction, %d per hour, 0 for no limit)",
+			key.PublicKey(), cfg.Signer.Listen, policy.MaxLamportsPerTx, policy.MaxLamportsPerHour)
+		errs <- server.ListenAndServe()
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-errs:
+		log.Printf("Signer stopped: %v", err)
+		return 1
+	case <-quit:
+	}
+
+	log.Println("Signer shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("Error during signer shutdown: %v", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// apiKeyPrefix marks faucet API keys so they are recognisable in configs
+// and secret scanners
+const apiKeyPrefix = "sfk_"
+
+// GenerateAPIKey creates a new random API key and the short prefix shown
+// when listing keys
+func GenerateAPIKey() (key, prefix string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + hex.EncodeToString(b)
+	return key, key[:len(apiKeyPrefix)+8], nil
+}
+
+// HashAPIKey returns the hash under which an API key is stored. Keys are
+// long and random, so a plain SHA-256 is enough to make a leaked database
+// useless for claiming.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
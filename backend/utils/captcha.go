@@ -0,0 +1,267 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Captcha providers accepted in the faucet configuration
+const (
+	CaptchaTurnstile   = "turnstile"
+	CaptchaHCaptcha    = "hcaptcha"
+	CaptchaRecaptcha   = "recaptcha"    // reCAPTCHA v2
+	CaptchaRecaptchaV3 = "recaptcha-v3" // reCAPTCHA v3, scored
+	CaptchaNone        = "none"
+)
+
+// Default siteverify endpoints of each provider
+const (
+	turnstileVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+	hcaptchaVerifyURL  = "https://api.hcaptcha.com/siteverify"
+	recaptchaVerifyURL = "https://www.google.com/recaptcha/api/siteverify"
+)
+
+// captchaVerifyTimeout bounds a single siteverify call
+const captchaVerifyTimeout = 10 * time.Second
+
+// captchaMaxTokenAge is how long after being solved a token is accepted.
+// Providers expire tokens after five minutes.
+const captchaMaxTokenAge = 5 * time.Minute
+
+// CaptchaVerifier checks a captcha token solved by a client
+type CaptchaVerifier interface {
+	// Verify reports whether the token is valid. remoteIP is passed on to
+	// the provider when known.
+	Verify(ctx context.Context, token, remoteIP string) (bool, error)
+}
+
+// CaptchaOptions selects and configures a captcha provider
+type CaptchaOptions struct {
+	Provider  string
+	SecretKey string
+
+	// VerifyURL overrides the provider's siteverify endpoint, e.g. to point
+	// at a local stub
+	VerifyURL string
+
+	// MinScore is the lowest reCAPTCHA v3 score accepted, from 0.0 to 1.0
+	MinScore float64
+
+	// Hostnames the widget may be served from; any hostname when empty
+	Hostnames []string
+
+	// Action the widget must have been rendered with; any action when empty
+	Action string
+
+	// DevBypass accepts every token without asking the provider. Only for
+	// local development.
+	DevBypass bool
+}
+
+// NewCaptchaVerifier creates the verifier for the configured provider
+func NewCaptchaVerifier(opts CaptchaOptions) (CaptchaVerifier, error) {
+	if opts.DevBypass {
+		return noCaptcha{}, nil
+	}
+
+	switch opts.Provider {
+	case "", CaptchaTurnstile:
+		if opts.SecretKey == "" {
+			return nil, fmt.Errorf("turnstile requires a secret key")
+		}
+		return NewTurnstileClient(opts.SecretKey, opts.VerifyURL, opts.Hostnames, opts.Action), nil
+	case CaptchaHCaptcha:
+		if opts.SecretKey == "" {
+			return nil, fmt.Errorf("hcaptcha requires a secret key")
+		}
+		return &siteVerifyClient{
+			name:       "hcaptcha",
+			secretKey:  opts.SecretKey,
+			verifyURL:  orDefault(opts.VerifyURL, hcaptchaVerifyURL),
+			hostnames:  opts.Hostnames,
+			httpClient: &http.Client{Timeout: captchaVerifyTimeout},
+		}, nil
+	case CaptchaRecaptcha, CaptchaRecaptchaV3:
+		if opts.SecretKey == "" {
+			return nil, fmt.Errorf("recaptcha requires a secret key")
+		}
+		client := &siteVerifyClient{
+			name:       "recaptcha",
+			secretKey:  opts.SecretKey,
+			verifyURL:  orDefault(opts.VerifyURL, recaptchaVerifyURL),
+			hostnames:  opts.Hostnames,
+			httpClient: &http.Client{Timeout: captchaVerifyTimeout},
+		}
+		if opts.Provider == CaptchaRecaptchaV3 {
+			client.scored = true
+			client.minScore = opts.MinScore
+			client.action = opts.Action
+		}
+		return client, nil
+	case CaptchaNone:
+		return noCaptcha{}, nil
+	default:
+		return nil, fmt.Errorf("unknown captcha provider %q", opts.Provider)
+	}
+}
+
+// siteVerifyResponse is the response shared by the Turnstile, hCaptcha and
+// reCAPTCHA siteverify APIs
+type siteVerifyResponse struct {
+	Success     bool     `json:"success"`
+	ChallengeTS string   `json:"challenge_ts"`
+	Hostname    string   `json:"hostname"`
+	ErrorCodes  []string `json:"error-codes"`
+	Score       *float64 `json:"score,omitempty"`  // reCAPTCHA v3 only
+	Action      string   `json:"action,omitempty"` // reCAPTCHA v3 and Turnstile
+}
+
+// siteVerify posts a form to a siteverify endpoint
+func siteVerify(ctx context.Context, httpClient *http.Client, verifyURL string, form url.Values) (*siteVerifyResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, captchaVerifyTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, verifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("siteverify returned HTTP %d", resp.StatusCode)
+	}
+
+	// Parse response
+	var result siteVerifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// siteVerifyForm builds the common siteverify request
+func siteVerifyForm(secretKey, token, remoteIP string) url.Values {
+	form := url.Values{
+		"secret":   {secretKey},
+		"response": {token},
+	}
+	if remoteIP != "" {
+		form.Set("remoteip", remoteIP)
+	}
+	return form
+}
+
+// checkClaims validates the hostname, action and age a provider reported
+// for a successfully solved token
+func (r *siteVerifyResponse) checkClaims(provider string, hostnames []string, action string) error {
+	if len(hostnames) > 0 && !containsFold(hostnames, r.Hostname) {
+		return fmt.Errorf("%s token was solved on unexpected hostname %q", provider, r.Hostname)
+	}
+	if action != "" && r.Action != action {
+		return fmt.Errorf("%s token has unexpected action %q", provider, r.Action)
+	}
+	if r.ChallengeTS != "" {
+		solvedAt, err := time.Parse(time.RFC3339, r.ChallengeTS)
+		if err == nil && time.Since(solvedAt) > captchaMaxTokenAge {
+			return fmt.Errorf("%s token is too old", provider)
+		}
+	}
+	return nil
+}
+
+// containsFold reports whether list contains s, ignoring case
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// newIdempotencyKey returns a random UUIDv4
+func newIdempotencyKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:], nil
+}
+
+// siteVerifyClient verifies hCaptcha and reCAPTCHA tokens
+type siteVerifyClient struct {
+	name       string
+	secretKey  string
+	verifyURL  string
+	hostnames  []string
+	httpClient *http.Client
+
+	// reCAPTCHA v3 returns a score and action instead of a pass/fail
+	// challenge
+	scored   bool
+	minScore float64
+	action   string
+}
+
+// Verify verifies a token with the provider
+func (c *siteVerifyClient) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	result, err := siteVerify(ctx, c.httpClient, c.verifyURL, siteVerifyForm(c.secretKey, token, remoteIP))
+	if err != nil {
+		return false, err
+	}
+
+	if !result.Success && len(result.ErrorCodes) > 0 {
+		return false, fmt.Errorf("%s verification failed: %v", c.name, result.ErrorCodes)
+	}
+	if !result.Success {
+		return false, nil
+	}
+
+	if c.scored {
+		if result.Score == nil {
+			return false, fmt.Errorf("%s verification returned no score", c.name)
+		}
+		if *result.Score < c.minScore {
+			return false, nil
+		}
+	}
+
+	if err := result.checkClaims(c.name, c.hostnames, c.action); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// noCaptcha accepts every request, for private deployments and the
+// development bypass
+type noCaptcha struct{}
+
+// Verify always succeeds
+func (noCaptcha) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	return true, nil
+}
+
+// orDefault returns value, or fallback when value is empty
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
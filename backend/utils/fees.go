@@ -0,0 +1,102 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/gagliardetto/solana-go"
+	computebudget "github.com/gagliardetto/solana-go/programs/compute-budget"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// lamportsPerSignature is the base fee charged for every transaction signature
+const lamportsPerSignature = 5000
+
+// Compute unit limits used when none is configured. They leave headroom over
+// what the instructions consume, including the compute budget instructions.
+const (
+	computeUnitsSOLTransfer   = 1000
+	computeUnitsTokenTransfer = 60000
+)
+
+// FeePolicy controls the compute budget and priority fee of faucet transfers
+type FeePolicy struct {
+	// ComputeUnitPrice is a fixed priority fee in micro-lamports per compute
+	// unit, used when Dynamic is false
+	ComputeUnitPrice uint64
+
+	// Dynamic derives the price from getRecentPrioritizationFees instead
+	Dynamic bool
+
+	// Percentile of recent prioritization fees used when Dynamic is set
+	Percentile int
+
+	// MaxPriorityFee caps the total priority fee per transaction in lamports,
+	// 0 for no cap
+	MaxPriorityFee uint64
+
+	// ComputeUnitLimit overrides the per-transfer compute unit limit when set
+	ComputeUnitLimit uint32
+}
+
+// computeBudget returns the compute budget instructions to prepend to a
+// transaction and the priority fee in lamports they commit to. No
+// instructions are added when the price works out to zero.
+func (c *SolanaClient) computeBudget(computeUnits uint32) ([]solana.Instruction, uint64) {
+	if c.fees.ComputeUnitLimit > 0 {
+		computeUnits = c.fees.ComputeUnitLimit
+	}
+
+	price := c.fees.ComputeUnitPrice
+	if c.fees.Dynamic {
+		price = c.recentPriorityFee(c.fees.Percentile)
+	}
+
+	// Respect the cap on the total priority fee
+	if c.fees.MaxPriorityFee > 0 && priorityFee(price, computeUnits) > c.fees.MaxPriorityFee {
+		price = c.fees.MaxPriorityFee * 1_000_000 / uint64(computeUnits)
+	}
+	if price == 0 {
+		return nil, 0
+	}
+
+	return []solana.Instruction{
+		computebudget.NewSetComputeUnitLimitInstruction(computeUnits).Build(),
+		computebudget.NewSetComputeUnitPriceInstruction(price).Build(),
+	}, priorityFee(price, computeUnits)
+}
+
+// recentPriorityFee returns the given percentile of the prioritization fees
+// recently paid for transactions writing to the faucet wallet
+func (c *SolanaClient) recentPriorityFee(percentile int) uint64 {
+	var fees []rpc.PriorizationFeeResult
+	err := c.pool.call("getRecentPrioritizationFees", func(ctx context.Context, client *rpc.Client) (err error) {
+		fees, err = client.GetRecentPrioritizationFees(ctx, solana.PublicKeySlice{c.publicKey})
+		return err
+	})
+	if err != nil {
+		// Fall back to the fixed price rather than failing the transfer
+		log.Printf("[Solana] Error getting recent prioritization fees: %v", err)
+		return c.fees.ComputeUnitPrice
+	}
+	if len(fees) == 0 {
+		return c.fees.ComputeUnitPrice
+	}
+
+	values := make([]uint64, len(fees))
+	for i, f := range fees {
+		values[i] = f.PrioritizationFee
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	if percentile <= 0 || percentile > 100 {
+		percentile = 75
+	}
+	return values[(len(values)-1)*percentile/100]
+}
+
+// priorityFee returns the lamports charged for a compute unit price and limit
+func priorityFee(microLamportsPerUnit uint64, computeUnits uint32) uint64 {
+	return (microLamportsPerUnit*uint64(computeUnits) + 999_999) / 1_000_000
+}
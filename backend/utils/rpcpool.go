@@ -0,0 +1,312 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+)
+
+const (
+	// failureThreshold is how many consecutive failures mark an endpoint down
+	failureThreshold = 3
+
+	// minBackoff and maxBackoff bound how long a down endpoint is skipped
+	minBackoff = 15 * time.Second
+	maxBackoff = 5 * time.Minute
+
+	// latencySmoothing is the weight of the newest sample in the latency EWMA
+	latencySmoothing = 0.2
+)
+
+// RPCEndpoint is a Solana RPC URL and its share of the traffic
+type RPCEndpoint struct {
+	URL    string
+	Weight int
+}
+
+// EndpointHealth is a snapshot of an endpoint's health for status reporting
+type EndpointHealth struct {
+	URL          string    `json:"url"`
+	Weight       int       `json:"weight"`
+	Healthy      bool      `json:"healthy"`
+	Requests     uint64    `json:"requests"`
+	Errors       uint64    `json:"errors"`
+	ErrorRate    float64   `json:"errorRate"`
+	AvgLatencyMs float64   `json:"avgLatencyMs"`
+	LastError    string    `json:"lastError,omitempty"`
+	LastErrorAt  time.Time `json:"lastErrorAt,omitempty"`
+	DownUntil    time.Time `json:"downUntil,omitempty"`
+}
+
+// poolEndpoint is a single RPC endpoint and its running statistics
+type poolEndpoint struct {
+	url    string
+	weight int
+	client *rpc.Client
+
+	mu                  sync.Mutex
+	requests            uint64
+	errors              uint64
+	avgLatency          time.Duration
+	consecutiveFailures int
+	downUntil           time.Time
+	lastError           string
+	lastErrorAt         time.Time
+}
+
+// rpcPool spreads calls over several RPC endpoints by weight, and fails over
+// to the next endpoint when one errors or times out
+type rpcPool struct {
+	endpoints []*poolEndpoint
+	timeout   time.Duration
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+// newRPCPool creates a pool over the given endpoints
+func newRPCPool(endpoints []RPCEndpoint, timeout time.Duration) (*rpcPool, error) {
+	if len(endpoints) == 0 {
+		return nil, errors.New("no RPC endpoints configured")
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	pool := &rpcPool{
+		timeout: timeout,
+		stopCh:  make(chan struct{}),
+	}
+	for _, e := range endpoints {
+		if _, err := url.ParseRequestURI(e.URL); err != nil {
+			return nil, fmt.Errorf("invalid RPC URL %q: %w", e.URL, err)
+		}
+		weight := e.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		pool.endpoints = append(pool.endpoints, &poolEndpoint{
+			url:    e.URL,
+			weight: weight,
+			client: rpc.New(e.URL),
+		})
+	}
+
+	return pool, nil
+}
+
+// call runs fn against endpoints in weighted order until one succeeds.
+// Errors reported by the RPC node itself, such as a failed preflight, are
+// returned immediately since another node would give the same answer.
+func (p *rpcPool) call(method string, fn func(ctx context.Context, client *rpc.Client) error) error {
+	var lastErr error
+	for _, e := range p.order() {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		start := time.Now()
+		err := fn(ctx, e.client)
+		cancel()
+
+		if errors.Is(err, context.Canceled) {
+			// Cancelled by the caller; says nothing about the endpoint
+			return err
+		}
+		if err == nil || !isEndpointError(err) {
+			e.recordSuccess(time.Since(start))
+			return err
+		}
+
+		e.recordFailure(err)
+		log.Printf("[Solana] %s failed on %s, trying next endpoint: %s", method, redactURL(e.url), scrubURLs(err.Error()))
+		lastErr = err
+	}
+	return lastErr
+}
+
+// order returns the endpoints to try: healthy ones in a weighted random
+// order, followed by the ones currently marked down as a last resort
+func (p *rpcPool) order() []*poolEndpoint {
+	now := time.Now()
+
+	var healthy, down []*poolEndpoint
+	totalWeight := 0
+	for _, e := range p.endpoints {
+		if e.isDown(now) {
+			down = append(down, e)
+			continue
+		}
+		healthy = append(healthy, e)
+		totalWeight += e.weight
+	}
+
+	// Weighted shuffle: repeatedly draw an endpoint proportionally to weight
+	ordered := make([]*poolEndpoint, 0, len(p.endpoints))
+	for len(healthy) > 0 {
+		pick := rand.Intn(totalWeight)
+		for i, e := range healthy {
+			if pick < e.weight {
+				ordered = append(ordered, e)
+				totalWeight -= e.weight
+				healthy = append(healthy[:i], healthy[i+1:]...)
+				break
+			}
+			pick -= e.weight
+		}
+	}
+
+	return append(ordered, down...)
+}
+
+// startHealthChecks probes every endpoint with getHealth on an interval so
+// down endpoints recover without having to fail a real request first
+func (p *rpcPool) startHealthChecks(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stopCh:
+				return
+			case <-ticker.C:
+				for _, e := range p.endpoints {
+					ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+					start := time.Now()
+					_, err := e.client.GetHealth(ctx)
+					cancel()
+					if err != nil {
+						e.recordFailure(err)
+					} else {
+						e.recordSuccess(time.Since(start))
+					}
+				}
+			}
+		}
+	}()
+}
+
+// stop ends the background health checks
+func (p *rpcPool) stop() {
+	p.stopOnce.Do(func() {
+		close(p.stopCh)
+	})
+}
+
+// health returns a snapshot of every endpoint's health
+func (p *rpcPool) health() []EndpointHealth {
+	now := time.Now()
+	health := make([]EndpointHealth, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		h := EndpointHealth{
+			URL:          redactURL(e.url),
+			Weight:       e.weight,
+			Healthy:      !now.Before(e.downUntil),
+			Requests:     e.requests,
+			Errors:       e.errors,
+			AvgLatencyMs: float64(e.avgLatency) / float64(time.Millisecond),
+			LastError:    e.lastError,
+			LastErrorAt:  e.lastErrorAt,
+		}
+		if e.requests > 0 {
+			h.ErrorRate = float64(e.errors) / float64(e.requests)
+		}
+		if !h.Healthy {
+			h.DownUntil = e.downUntil
+		}
+		e.mu.Unlock()
+		health = append(health, h)
+	}
+	return health
+}
+
+// isDown reports whether the endpoint is in its failure backoff
+func (e *poolEndpoint) isDown(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return now.Before(e.downUntil)
+}
+
+// recordSuccess updates the statistics after a successful call
+func (e *poolEndpoint) recordSuccess(latency time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests++
+	if e.avgLatency == 0 {
+		e.avgLatency = latency
+	} else {
+		e.avgLatency = time.Duration(latencySmoothing*float64(latency) + (1-latencySmoothing)*float64(e.avgLatency))
+	}
+	e.consecutiveFailures = 0
+	e.downUntil = time.Time{}
+}
+
+// recordFailure updates the statistics after a failed call and takes the
+// endpoint out of rotation with exponential backoff once it keeps failing
+func (e *poolEndpoint) recordFailure(err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.requests++
+	e.errors++
+	e.consecutiveFailures++
+	e.lastError = scrubURLs(err.Error())
+	e.lastErrorAt = time.Now()
+
+	if e.consecutiveFailures >= failureThreshold {
+		backoff := minBackoff << uint(e.consecutiveFailures-failureThreshold)
+		if backoff > maxBackoff || backoff <= 0 {
+			backoff = maxBackoff
+		}
+		e.downUntil = time.Now().Add(backoff)
+	}
+}
+
+// isEndpointError reports whether an error says something about the endpoint
+// (unreachable, overloaded, behind) rather than about the request itself
+func isEndpointError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		// The caller gave up; the endpoint did nothing wrong
+		return false
+	}
+	var rpcErr *jsonrpc.RPCError
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.Code {
+		case 429, -32005, -32603: // rate limited, node unhealthy, internal error
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+// urlPattern matches URLs embedded in error messages
+var urlPattern = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"']+`)
+
+// scrubURLs redacts every URL in an error message, so keys in the path or
+// query never reach the status endpoint
+func scrubURLs(msg string) string {
+	return urlPattern.ReplaceAllStringFunc(msg, redactURL)
+}
+
+// redactURL strips the path and query from an RPC URL, which for hosted
+// providers often contain an API key
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "invalid-url"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+)
+
+func TestScrubURLs(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want string
+	}{
+		{
+			`Post "https://mainnet.example.com/v2/secret-key": dial tcp: i/o timeout`,
+			`Post "https://mainnet.example.com": dial tcp: i/o timeout`,
+		},
+		{
+			"bad status from https://rpc.example.com/?api-key=abc123 (429)",
+			"bad status from https://rpc.example.com (429)",
+		},
+		{"connection refused", "connection refused"},
+	}
+	for _, tt := range tests {
+		if got := scrubURLs(tt.msg); got != tt.want {
+			t.Errorf("scrubURLs(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestIsEndpointError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"transport error", errors.New("connection refused"), true},
+		{"timeout", context.DeadlineExceeded, true},
+		{"cancelled by the caller", fmt.Errorf("call: %w", context.Canceled), false},
+		{"rate limited", &jsonrpc.RPCError{Code: 429}, true},
+		{"node unhealthy", &jsonrpc.RPCError{Code: -32005}, true},
+		{"preflight failure", &jsonrpc.RPCError{Code: -32002}, false},
+	}
+	for _, tt := range tests {
+		if got := isEndpointError(tt.err); got != tt.want {
+			t.Errorf("%s: isEndpointError = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
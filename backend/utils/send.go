@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// RetryPolicy controls how a transfer is retried until it lands
+type RetryPolicy struct {
+	// MaxAttempts is how many blockhashes a transfer is signed with before
+	// giving up
+	MaxAttempts int
+
+	// RebroadcastInterval is how often a signed transaction that hasn't been
+	// seen by the cluster is sent again while its blockhash is valid
+	RebroadcastInterval time.Duration
+
+	// Backoff is the wait before re-signing after an attempt expired. It
+	// doubles with every attempt.
+	Backoff time.Duration
+
+	// StatusTimeout is how long a sent transaction may go without a block
+	// height from the cluster before its outcome is given up as unknown
+	StatusTimeout time.Duration
+}
+
+// DefaultRetryPolicy is used when no retry policy is configured
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:         3,
+	RebroadcastInterval: 2 * time.Second,
+	Backoff:             500 * time.Millisecond,
+	StatusTimeout:       30 * time.Second,
+}
+
+// maxTransactionSize is the largest serialized transaction the cluster
+// accepts, the IPv6 MTU minus headers
+const maxTransactionSize = 1232
+
+// signTimeout bounds how long a signer, possibly a remote daemon, may take
+// to sign a transaction
+const signTimeout = 15 * time.Second
+
+// MaxSOLBatchSize is how many system transfers fit in one transaction next
+// to the compute budget instructions: 218 bytes of signature, header, fixed
+// accounts, blockhash and budget, plus 49 bytes per recipient account and
+// transfer instruction
+const MaxSOLBatchSize = 20
+
+// errBlockhashExpired is returned by awaitLanding when a transaction can no
+// longer land
+var errBlockhashExpired = errors.New("blockhash expired")
+
+// ErrOutcomeUnknown is wrapped by send errors after which the transaction may
+// still have landed. Its transfers must not be retried; the send returns the
+// transaction along with the error so its status can be checked later.
+var ErrOutcomeUnknown = errors.New("transaction outcome unknown")
+
+// SignedFunc is called with every signed transaction before it's broadcast,
+// so the caller can persist its signature first. An error aborts the send
+// before anything reaches the cluster.
+type SignedFunc func(sent *SentTransaction) error
+
+// sendInstructions builds, signs and sends a transaction paid for by the
+// faucet wallet, and keeps rebroadcasting it until the cluster has seen it.
+//
+// A transaction is only ever re-signed with a fresh blockhash once the
+// previous one has expired and a history lookup confirms it never landed, so
+// a recipient can't be paid twice.
+func (c *SolanaClient) sendInstructions(instructions []solana.Instruction, computeUnits uint32, onSigned SignedFunc) (*SentTransaction, error) {
+	policy := c.retry
+	if policy.MaxAttempts <= 0 {
+		policy.MaxAttempts = 1
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			backoff := policy.Backoff << uint(attempt-2)
+			log.Printf("[Solana] Re-signing transaction in %s (attempt %d/%d)", backoff, attempt, policy.MaxAttempts)
+			time.Sleep(backoff)
+		}
+
+		sent, err := c.sendAttempt(instructions, computeUnits, policy, onSigned)
+		if err == nil {
+			return sent, nil
+		}
+		if !errors.Is(err, errBlockhashExpired) {
+			// sent is only set when the outcome is unknown
+			return sent, err
+		}
+		lastErr = err
+	}
+
+	return nil, fmt.Errorf("failed to send transaction after %d attempts: %w", policy.MaxAttempts, lastErr)
+}
+
+// sendAttempt signs the instructions with a fresh blockhash and priority fee
+// and broadcasts the transaction until it lands or the blockhash expires. It
+// returns errBlockhashExpired only when it's certain the transaction didn't
+// land.
+func (c *SolanaClient) sendAttempt(instructions []solana.Instruction, computeUnits uint32, policy RetryPolicy, onSigned SignedFunc) (*SentTransaction, error) {
+	// Prepend the compute budget, priced for current congestion
+	budget, priorityFee := c.computeBudget(computeUnits)
+	instructions = append(budget, instructions...)
+
+	// Get recent blockhash
+	var recent *rpc.GetLatestBlockhashResult
+	err := c.pool.call("getLatestBlockhash", func(ctx context.Context, client *rpc.Client) (err error) {
+		recent, err = client.GetLatestBlockhash(ctx, rpc.CommitmentConfirmed)
+		return err
+	})
+	if err != nil {
+		log.Printf("[Solana] Error getting recent blockhash: %v", err)
+		return nil, fmt.Errorf("failed to get recent blockhash: %w", err)
+	}
+
+	// Build transaction
+	tx, err := solana.NewTransaction(
+		instructions,
+		recent.Value.Blockhash,
+		solana.TransactionPayer(c.publicKey),
+	)
+	if err != nil {
+		log.Printf("[Solana] Error creating transaction: %v", err)
+		return nil, fmt.Errorf("failed to create transaction: %w", err)
+	}
+
+	// Sign transaction. The faucet wallet is its only signer.
+	message, err := tx.Message.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize message: %w", err)
+	}
+	if tx.Message.Header.NumRequiredSignatures != 1 {
+		return nil, fmt.Errorf("transaction needs %d signatures, only the faucet wallet signs", tx.Message.Header.NumRequiredSignatures)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), signTimeout)
+	sig, err := c.signer.Sign(ctx, message)
+	cancel()
+	if err != nil {
+		log.Printf("[Solana] Error signing transaction: %v", err)
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+	tx.Signatures = []solana.Signature{sig}
+
+	// Refuse transactions the cluster would drop for their size
+	data, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize transaction: %w", err)
+	}
+	if len(data) > maxTransactionSize {
+		return nil, fmt.Errorf("transaction is %d bytes, over the %d byte limit", len(data), maxTransactionSize)
+	}
+
+	sent := &SentTransaction{
+		Signature:            tx.Signatures[0].String(),
+		LastValidBlockHeight: recent.Value.LastValidBlockHeight,
+		FeeLamports:          uint64(len(tx.Signatures))*lamportsPerSignature + priorityFee,
+	}
+	if onSigned != nil {
+		if err := onSigned(sent); err != nil {
+			return nil, fmt.Errorf("failed to record signature: %w", err)
+		}
+	}
+
+	// Send transaction with preflight. A rejection by the node means it was
+	// never forwarded, so it's safe to give up; a transport error leaves the
+	// transaction possibly in flight, so keep watching it.
+	err = c.broadcast(tx, false)
+	if err != nil && !isEndpointError(err) {
+		log.Printf("[Solana] Error sending transaction: %v", err)
+		return nil, fmt.Errorf("failed to send transaction: %w", err)
+	}
+	if err != nil {
+		log.Printf("[Solana] Send of %s may not have reached the cluster, rebroadcasting: %v", sent.Signature, err)
+	} else {
+		log.Printf("[Solana] Transaction sent: %s", sent.Signature)
+	}
+
+	if err := c.awaitLanding(tx, sent, policy.RebroadcastInterval, policy.StatusTimeout); err != nil {
+		if errors.Is(err, ErrOutcomeUnknown) {
+			return sent, err
+		}
+		return nil, err
+	}
+
+	return sent, nil
+}
+
+// broadcast submits a signed transaction to the cluster
+func (c *SolanaClient) broadcast(tx *solana.Transaction, skipPreflight bool) error {
+	// Resending the same signed transaction to another endpoint is safe: the
+	// cluster deduplicates by signature
+	return c.pool.call("sendTransaction", func(ctx context.Context, client *rpc.Client) error {
+		_, err := client.SendTransactionWithOpts(
+			ctx,
+			tx,
+			rpc.TransactionOpts{
+				SkipPreflight:       skipPreflight,
+				PreflightCommitment: rpc.CommitmentConfirmed,
+			},
+		)
+		return err
+	})
+}
+
+// awaitLanding rebroadcasts a signed transaction until the cluster reports a
+// status for it. Once its blockhash has expired it checks the full history
+// one last time and returns errBlockhashExpired if the transaction is gone.
+// If the block height can't be read for statusTimeout, expiry can't be
+// decided and it returns ErrOutcomeUnknown.
+func (c *SolanaClient) awaitLanding(tx *solana.Transaction, sent *SentTransaction, rebroadcastInterval, statusTimeout time.Duration) error {
+	if rebroadcastInterval <= 0 {
+		rebroadcastInterval = DefaultRetryPolicy.RebroadcastInterval
+	}
+	if statusTimeout <= 0 {
+		statusTimeout = DefaultRetryPolicy.StatusTimeout
+	}
+
+	deadline := time.Now().Add(statusTimeout)
+	for {
+		time.Sleep(rebroadcastInterval)
+
+		landed, err := c.hasLanded(sent.Signature, false)
+		if err == nil && landed {
+			return nil
+		}
+
+		height, err := c.GetBlockHeight(rpc.CommitmentConfirmed)
+		if err != nil {
+			// Can't tell whether it expired; keep trying rather than re-sign,
+			// but not forever
+			if time.Now().After(deadline) {
+				return fmt.Errorf("failed to get block height for %s: %v: %w", sent.Signature, err, ErrOutcomeUnknown)
+			}
+			continue
+		}
+		deadline = time.Now().Add(statusTimeout)
+
+		if height > sent.LastValidBlockHeight {
+			// The transaction can no longer land; make sure it didn't
+			landed, err := c.hasLanded(sent.Signature, true)
+			if err != nil {
+				return fmt.Errorf("failed to check status of expired transaction %s: %v: %w", sent.Signature, err, ErrOutcomeUnknown)
+			}
+			if landed {
+				return nil
+			}
+			log.Printf("[Solana] Transaction %s expired without landing", sent.Signature)
+			return errBlockhashExpired
+		}
+
+		if err := c.broadcast(tx, true); err != nil {
+			log.Printf("[Solana] Error rebroadcasting %s: %v", sent.Signature, err)
+		}
+	}
+}
+
+// hasLanded reports whether the cluster has any status for a signature
+func (c *SolanaClient) hasLanded(signature string, searchHistory bool) (bool, error) {
+	statuses, err := c.GetSignatureStatuses([]string{signature}, searchHistory)
+	if err != nil {
+		return false, err
+	}
+	return len(statuses.Value) > 0 && statuses.Value[0] != nil, nil
+}
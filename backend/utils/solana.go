@@ -4,46 +4,59 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/programs/system"
 	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/maestroi/solana-faucet/backend/signer"
 )
 
 // SolanaClient is a client for interacting with the Solana blockchain
 type SolanaClient struct {
-	rpcClient *rpc.Client
-	wallet    solana.PrivateKey
+	pool      *rpcPool
+	signer    signer.Signer
 	publicKey solana.PublicKey
+	retry     RetryPolicy
+	fees      FeePolicy
+
+	// Mints without a transfer fee are cached after the first lookup
+	mintMutex sync.RWMutex
+	mints     map[solana.PublicKey]*mintInfo
 }
 
-// NewSolanaClient creates a new Solana client
-func NewSolanaClient(rpcURL, walletPath string) (*SolanaClient, error) {
-	// Create RPC client
-	rpcClient := rpc.New(rpcURL)
+// ClientOptions configures how a SolanaClient talks to the cluster
+type ClientOptions struct {
+	Endpoints           []RPCEndpoint
+	RPCTimeout          time.Duration
+	HealthCheckInterval time.Duration
+	Retry               RetryPolicy
+	Fees                FeePolicy
+}
 
-	// Load wallet
-	data, err := ioutil.ReadFile(walletPath)
+// NewSolanaClient creates a new Solana client that spreads RPC calls over
+// the configured endpoints by weight and fails over between them, signing
+// with the given signer
+func NewSolanaClient(opts ClientOptions, walletSigner signer.Signer) (*SolanaClient, error) {
+	// Create RPC pool
+	pool, err := newRPCPool(opts.Endpoints, opts.RPCTimeout)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read wallet file: %w", err)
+		return nil, err
 	}
 
-	// Parse wallet data
-	var keyBytes []byte
-	if err := json.Unmarshal(data, &keyBytes); err != nil {
-		return nil, fmt.Errorf("failed to parse wallet: %w", err)
-	}
+	publicKey := walletSigner.PublicKey()
 
-	// Create private key from bytes
-	wallet := solana.PrivateKey(keyBytes)
-	publicKey := wallet.PublicKey()
+	pool.startHealthChecks(opts.HealthCheckInterval)
 
 	return &SolanaClient{
-		rpcClient: rpcClient,
-		wallet:    wallet,
+		pool:      pool,
+		signer:    walletSigner,
 		publicKey: publicKey,
+		retry:     opts.Retry,
+		fees:      opts.Fees,
+		mints:     make(map[solana.PublicKey]*mintInfo),
 	}, nil
 }
 
@@ -59,11 +72,11 @@ func (c *SolanaClient) GetBalance(address string) (float64, error) {
 	}
 
 	// Get balance
-	balance, err := c.rpcClient.GetBalance(
-		context.Background(),
-		pubKey,
-		rpc.CommitmentConfirmed,
-	)
+	var balance *rpc.GetBalanceResult
+	err = c.pool.call("getBalance", func(ctx context.Context, client *rpc.Client) (err error) {
+		balance, err = client.GetBalance(ctx, pubKey, rpc.CommitmentConfirmed)
+		return err
+	})
 	if err != nil {
 		log.Printf("[Solana] Error getting balance: %v", err)
 		return 0, fmt.Errorf("failed to get balance: %w", err)
@@ -82,15 +95,23 @@ func IsValidSolanaAddress(address string) bool {
 	return err == nil
 }
 
-// SendSOL sends SOL from the faucet wallet to the specified address
-func (c *SolanaClient) SendSOL(toAddress string, amount float64) (string, error) {
+// SentTransaction describes a transaction submitted to the cluster
+type SentTransaction struct {
+	Signature            string
+	LastValidBlockHeight uint64
+	FeeLamports          uint64 // base fee plus priority fee
+}
+
+// SendSOL sends SOL from the faucet wallet to the specified address.
+// onSigned, if set, is called before each signed transaction is broadcast.
+func (c *SolanaClient) SendSOL(toAddress string, amount float64, onSigned SignedFunc) (*SentTransaction, error) {
 	log.Printf("[Solana] Sending %f SOL to %s", amount, toAddress)
 
 	// Parse recipient address
 	recipient, err := solana.PublicKeyFromBase58(toAddress)
 	if err != nil {
 		log.Printf("[Solana] Invalid recipient address: %s", toAddress)
-		return "", fmt.Errorf("invalid recipient address: %w", err)
+		return nil, fmt.Errorf("invalid recipient address: %w", err)
 	}
 
 	// Convert SOL to lamports
@@ -103,52 +124,45 @@ func (c *SolanaClient) SendSOL(toAddress string, amount float64) (string, error)
 		recipient,
 	).Build()
 
-	// Get recent blockhash
-	recent, err := c.rpcClient.GetLatestBlockhash(context.Background(), rpc.CommitmentConfirmed)
-	if err != nil {
-		log.Printf("[Solana] Error getting recent blockhash: %v", err)
-		return "", fmt.Errorf("failed to get recent blockhash: %w", err)
-	}
+	return c.sendInstructions([]solana.Instruction{instruction}, computeUnitsSOLTransfer, onSigned)
+}
 
-	// Build transaction
-	tx, err := solana.NewTransaction(
-		[]solana.Instruction{instruction},
-		recent.Value.Blockhash,
-		solana.TransactionPayer(c.publicKey),
-	)
-	if err != nil {
-		log.Printf("[Solana] Error creating transaction: %v", err)
-		return "", fmt.Errorf("failed to create transaction: %w", err)
-	}
+// SOLTransfer is one recipient of a batched SOL transfer
+type SOLTransfer struct {
+	ToAddress string
+	Amount    float64 // in SOL
+}
 
-	// Sign transaction
-	_, err = tx.Sign(func(key solana.PublicKey) *solana.PrivateKey {
-		if key.Equals(c.publicKey) {
-			return &c.wallet
-		}
-		return nil
-	})
-	if err != nil {
-		log.Printf("[Solana] Error signing transaction: %v", err)
-		return "", fmt.Errorf("failed to sign transaction: %w", err)
+// SendSOLBatch pays several recipients with one transaction, one system
+// transfer instruction each, so they share a signature and a single fee. At
+// most MaxSOLBatchSize transfers fit in a transaction.
+// onSigned, if set, is called before each signed transaction is broadcast.
+func (c *SolanaClient) SendSOLBatch(transfers []SOLTransfer, onSigned SignedFunc) (*SentTransaction, error) {
+	if len(transfers) == 0 {
+		return nil, fmt.Errorf("no transfers to send")
 	}
+	if len(transfers) > MaxSOLBatchSize {
+		return nil, fmt.Errorf("batch of %d transfers exceeds the maximum of %d", len(transfers), MaxSOLBatchSize)
+	}
+	log.Printf("[Solana] Sending batch of %d SOL transfers", len(transfers))
+
+	instructions := make([]solana.Instruction, 0, len(transfers))
+	for _, t := range transfers {
+		// Parse recipient address
+		recipient, err := solana.PublicKeyFromBase58(t.ToAddress)
+		if err != nil {
+			log.Printf("[Solana] Invalid recipient address: %s", t.ToAddress)
+			return nil, fmt.Errorf("invalid recipient address %s: %w", t.ToAddress, err)
+		}
 
-	// Send transaction
-	sig, err := c.rpcClient.SendTransactionWithOpts(
-		context.Background(),
-		tx,
-		rpc.TransactionOpts{
-			SkipPreflight:       false,
-			PreflightCommitment: rpc.CommitmentConfirmed,
-		},
-	)
-	if err != nil {
-		log.Printf("[Solana] Error sending transaction: %v", err)
-		return "", fmt.Errorf("failed to send transaction: %w", err)
+		instructions = append(instructions, system.NewTransferInstruction(
+			uint64(t.Amount*1e9),
+			c.publicKey,
+			recipient,
+		).Build())
 	}
 
-	log.Printf("[Solana] Transaction sent: %s", sig.String())
-	return sig.String(), nil
+	return c.sendInstructions(instructions, computeUnitsSOLTransfer*uint32(len(transfers)), onSigned)
 }
 
 // GetFaucetBalance returns the balance of the faucet wallet in lamports
@@ -156,11 +170,11 @@ func (c *SolanaClient) GetFaucetBalance() (uint64, error) {
 	log.Printf("[Solana] Getting faucet wallet balance for address: %s", c.publicKey)
 
 	// Get balance
-	balance, err := c.rpcClient.GetBalance(
-		context.Background(),
-		c.publicKey,
-		rpc.CommitmentConfirmed,
-	)
+	var balance *rpc.GetBalanceResult
+	err := c.pool.call("getBalance", func(ctx context.Context, client *rpc.Client) (err error) {
+		balance, err = client.GetBalance(ctx, c.publicKey, rpc.CommitmentConfirmed)
+		return err
+	})
 	if err != nil {
 		log.Printf("[Solana] Error getting balance: %v", err)
 		return 0, fmt.Errorf("failed to get balance: %w", err)
@@ -169,3 +183,72 @@ func (c *SolanaClient) GetFaucetBalance() (uint64, error) {
 	log.Printf("[Solana] Faucet balance: %d lamports", balance.Value)
 	return balance.Value, nil
 }
+
+// GetSignatureStatuses returns the on-chain status of the given transaction signatures
+func (c *SolanaClient) GetSignatureStatuses(signatures []string, searchHistory bool) (*rpc.GetSignatureStatusesResult, error) {
+	// Parse signatures
+	sigs := make([]solana.Signature, 0, len(signatures))
+	for _, s := range signatures {
+		sig, err := solana.SignatureFromBase58(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature %q: %w", s, err)
+		}
+		sigs = append(sigs, sig)
+	}
+
+	// Get statuses
+	var statuses *rpc.GetSignatureStatusesResult
+	err := c.pool.call("getSignatureStatuses", func(ctx context.Context, client *rpc.Client) (err error) {
+		statuses, err = client.GetSignatureStatuses(ctx, searchHistory, sigs...)
+		return err
+	})
+	if err != nil {
+		log.Printf("[Solana] Error getting signature statuses: %v", err)
+		return nil, fmt.Errorf("failed to get signature statuses: %w", err)
+	}
+
+	return statuses, nil
+}
+
+// GetBlockHeight returns the current block height at the given commitment
+func (c *SolanaClient) GetBlockHeight(commitment rpc.CommitmentType) (uint64, error) {
+	var height uint64
+	err := c.pool.call("getBlockHeight", func(ctx context.Context, client *rpc.Client) (err error) {
+		height, err = client.GetBlockHeight(ctx, commitment)
+		return err
+	})
+	if err != nil {
+		log.Printf("[Solana] Error getting block height: %v", err)
+		return 0, fmt.Errorf("failed to get block height: %w", err)
+	}
+	return height, nil
+}
+
+// ProxyRPC forwards a JSON-RPC call to the cluster as is and returns its raw
+// result. Callers decide which methods are safe to forward.
+func (c *SolanaClient) ProxyRPC(method string, params []json.RawMessage) (json.RawMessage, error) {
+	args := make([]interface{}, len(params))
+	for i, param := range params {
+		args[i] = param
+	}
+
+	var result json.RawMessage
+	err := c.pool.call(method, func(ctx context.Context, client *rpc.Client) error {
+		return client.RPCCallForInto(ctx, &result, method, args)
+	})
+	if err != nil {
+		log.Printf("[Solana] Error proxying %s: %v", method, err)
+		return nil, fmt.Errorf("failed to call %s: %w", method, err)
+	}
+	return result, nil
+}
+
+// RPCHealth returns the health of every configured RPC endpoint
+func (c *SolanaClient) RPCHealth() []EndpointHealth {
+	return c.pool.health()
+}
+
+// Close stops the background RPC health checks
+func (c *SolanaClient) Close() {
+	c.pool.stop()
+}
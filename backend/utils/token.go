@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// Token program names accepted in the faucet configuration
+const (
+	TokenProgramSPL       = "spl-token"
+	TokenProgramToken2022 = "token-2022"
+)
+
+const (
+	// mintDecimalsOffset is the position of the decimals byte in a mint
+	// account: mint authority option (36 bytes) followed by supply (8 bytes)
+	mintDecimalsOffset = 44
+
+	// Token-2022 extensions follow the base account, padded to the size of a
+	// token account, and a one byte account type
+	token2022ExtensionsOffset = 166
+
+	// extensionTransferFeeConfig is the TLV type of the transfer fee extension
+	extensionTransferFeeConfig = 1
+
+	// transferFeeConfigSize is two authorities, the withheld amount and two
+	// TransferFee entries (epoch, maximum fee, basis points)
+	transferFeeConfigSize = 32 + 32 + 8 + 18 + 18
+
+	// instructionTransferChecked is the TransferChecked instruction index,
+	// shared by the SPL Token and Token-2022 programs
+	instructionTransferChecked = 12
+)
+
+// transferFee is one epoch-scoped fee schedule of a transfer fee extension
+type transferFee struct {
+	Epoch       uint64
+	MaximumFee  uint64
+	BasisPoints uint16
+}
+
+// mintInfo is what the faucet needs to know about a mint to transfer it
+type mintInfo struct {
+	Program  solana.PublicKey
+	Decimals uint8
+
+	// Set for Token-2022 mints with the transfer fee extension
+	OlderTransferFee *transferFee
+	NewerTransferFee *transferFee
+}
+
+// tokenProgramID maps a configured program name to its program ID
+func tokenProgramID(program string) (solana.PublicKey, error) {
+	switch program {
+	case "", TokenProgramSPL:
+		return solana.TokenProgramID, nil
+	case TokenProgramToken2022:
+		return solana.Token2022ProgramID, nil
+	default:
+		return solana.PublicKey{}, fmt.Errorf("unknown token program %q", program)
+	}
+}
+
+// associatedTokenAddress derives the associated token account of a wallet
+// for a mint owned by the given token program
+func associatedTokenAddress(wallet, mint, tokenProgram solana.PublicKey) (solana.PublicKey, error) {
+	addr, _, err := solana.FindProgramAddress(
+		[][]byte{wallet[:], tokenProgram[:], mint[:]},
+		solana.SPLAssociatedTokenAccountProgramID,
+	)
+	return addr, err
+}
+
+// newCreateIdempotentATAInstruction builds the associated token account
+// program's CreateIdempotent instruction, which succeeds whether or not the
+// account already exists
+func newCreateIdempotentATAInstruction(payer, ata, wallet, mint, tokenProgram solana.PublicKey) solana.Instruction {
+	return solana.NewInstruction(
+		solana.SPLAssociatedTokenAccountProgramID,
+		solana.AccountMetaSlice{
+			solana.Meta(payer).WRITE().SIGNER(),
+			solana.Meta(ata).WRITE(),
+			solana.Meta(wallet),
+			solana.Meta(mint),
+			solana.Meta(solana.SystemProgramID),
+			solana.Meta(tokenProgram),
+		},
+		[]byte{1}, // CreateIdempotent
+	)
+}
+
+// newTransferCheckedInstruction builds a TransferChecked instruction for
+// either token program
+func newTransferCheckedInstruction(tokenProgram, source, mint, destination, owner solana.PublicKey, amount uint64, decimals uint8) solana.Instruction {
+	data := make([]byte, 10)
+	data[0] = instructionTransferChecked
+	binary.LittleEndian.PutUint64(data[1:9], amount)
+	data[9] = decimals
+
+	return solana.NewInstruction(
+		tokenProgram,
+		solana.AccountMetaSlice{
+			solana.Meta(source).WRITE(),
+			solana.Meta(mint),
+			solana.Meta(destination).WRITE(),
+			solana.Meta(owner).SIGNER(),
+		},
+		data,
+	)
+}
+
+// getMint loads a mint owned by the expected token program. Mints without a
+// transfer fee are cached, since their decimals never change.
+func (c *SolanaClient) getMint(mint, program solana.PublicKey) (*mintInfo, error) {
+	c.mintMutex.RLock()
+	cached, ok := c.mints[mint]
+	c.mintMutex.RUnlock()
+	if ok {
+		return cached, nil
+	}
+
+	var account *rpc.GetAccountInfoResult
+	err := c.pool.call("getAccountInfo", func(ctx context.Context, client *rpc.Client) (err error) {
+		account, err = client.GetAccountInfo(ctx, mint)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get mint account: %w", err)
+	}
+	if !account.Value.Owner.Equals(program) {
+		return nil, fmt.Errorf("mint %s is owned by %s, not the configured token program %s", mint, account.Value.Owner, program)
+	}
+	data := account.Value.Data.GetBinary()
+	if len(data) <= mintDecimalsOffset {
+		return nil, fmt.Errorf("account %s is not a valid mint", mint)
+	}
+
+	info := &mintInfo{
+		Program:  program,
+		Decimals: data[mintDecimalsOffset],
+	}
+	if program.Equals(solana.Token2022ProgramID) {
+		parseMintExtensions(data, info)
+	}
+
+	if info.NewerTransferFee == nil {
+		c.mintMutex.Lock()
+		c.mints[mint] = info
+		c.mintMutex.Unlock()
+	}
+
+	return info, nil
+}
+
+// parseMintExtensions reads the Token-2022 extensions the faucet cares about
+// from a mint account's TLV data
+func parseMintExtensions(data []byte, info *mintInfo) {
+	for offset := token2022ExtensionsOffset; offset+4 <= len(data); {
+		extType := binary.LittleEndian.Uint16(data[offset:])
+		length := int(binary.LittleEndian.Uint16(data[offset+2:]))
+		value := data[offset+4:]
+		if length > len(value) {
+			return
+		}
+		value = value[:length]
+
+		if extType == extensionTransferFeeConfig && length >= transferFeeConfigSize {
+			info.OlderTransferFee = parseTransferFee(value[72:90])
+			info.NewerTransferFee = parseTransferFee(value[90:108])
+		}
+
+		offset += 4 + length
+	}
+}
+
+// parseTransferFee decodes an 18 byte TransferFee
+func parseTransferFee(data []byte) *transferFee {
+	return &transferFee{
+		Epoch:       binary.LittleEndian.Uint64(data[0:8]),
+		MaximumFee:  binary.LittleEndian.Uint64(data[8:16]),
+		BasisPoints: binary.LittleEndian.Uint16(data[16:18]),
+	}
+}
+
+// activeFee returns the transfer fee in force at the given epoch
+func (m *mintInfo) activeFee(epoch uint64) *transferFee {
+	if m.NewerTransferFee == nil {
+		return nil
+	}
+	if epoch >= m.NewerTransferFee.Epoch {
+		return m.NewerTransferFee
+	}
+	return m.OlderTransferFee
+}
+
+// calculateFee returns the fee withheld from a transfer of amount
+func (f *transferFee) calculateFee(amount uint64) uint64 {
+	if f.BasisPoints == 0 || amount == 0 {
+		return 0
+	}
+	fee := (amount*uint64(f.BasisPoints) + 9999) / 10000
+	if fee > f.MaximumFee {
+		return f.MaximumFee
+	}
+	return fee
+}
+
+// grossAmount returns the smallest transfer amount whose net after fees is at
+// least net
+func (f *transferFee) grossAmount(net uint64) uint64 {
+	if f.BasisPoints == 0 {
+		return net
+	}
+	if f.BasisPoints >= 10000 {
+		return net + f.MaximumFee
+	}
+
+	// Invert the percentage fee, then nudge up for rounding and the cap
+	extra := (net*uint64(f.BasisPoints) + uint64(10000-f.BasisPoints) - 1) / uint64(10000-f.BasisPoints)
+	if extra > f.MaximumFee {
+		extra = f.MaximumFee
+	}
+	gross := net + extra
+	for gross-f.calculateFee(gross) < net {
+		gross++
+	}
+	return gross
+}
+
+// SendToken sends tokens from the faucet's associated token account to the
+// recipient's, creating the recipient's account if it doesn't exist yet. The
+// amount is in whole tokens and is what the recipient receives: for
+// Token-2022 mints with a transfer fee the faucet pays the fee on top.
+// onSigned, if set, is called before each signed transaction is broadcast.
+func (c *SolanaClient) SendToken(toAddress, mintAddress, program string, amount float64, onSigned SignedFunc) (*SentTransaction, error) {
+	log.Printf("[Solana] Sending %f of %s to %s", amount, mintAddress, toAddress)
+
+	// Parse addresses
+	recipient, err := solana.PublicKeyFromBase58(toAddress)
+	if err != nil {
+		log.Printf("[Solana] Invalid recipient address: %s", toAddress)
+		return nil, fmt.Errorf("invalid recipient address: %w", err)
+	}
+	mint, err := solana.PublicKeyFromBase58(mintAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mint address: %w", err)
+	}
+	programID, err := tokenProgramID(program)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the mint
+	info, err := c.getMint(mint, programID)
+	if err != nil {
+		log.Printf("[Solana] Error loading mint: %v", err)
+		return nil, err
+	}
+
+	// Convert to base units, adding any transfer fee
+	baseUnits := uint64(math.Round(amount * math.Pow10(int(info.Decimals))))
+	if info.NewerTransferFee != nil {
+		var epoch *rpc.GetEpochInfoResult
+		err := c.pool.call("getEpochInfo", func(ctx context.Context, client *rpc.Client) (err error) {
+			epoch, err = client.GetEpochInfo(ctx, rpc.CommitmentConfirmed)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get epoch info: %w", err)
+		}
+		if fee := info.activeFee(epoch.Epoch); fee != nil {
+			gross := fee.grossAmount(baseUnits)
+			log.Printf("[Solana] Mint %s charges a transfer fee, sending %d for a net of %d", mint, gross, baseUnits)
+			baseUnits = gross
+		}
+	}
+
+	// Derive token accounts
+	source, err := associatedTokenAddress(c.publicKey, mint, programID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive faucet token account: %w", err)
+	}
+	destination, err := associatedTokenAddress(recipient, mint, programID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive recipient token account: %w", err)
+	}
+
+	instructions := []solana.Instruction{
+		newCreateIdempotentATAInstruction(c.publicKey, destination, recipient, mint, programID),
+		newTransferCheckedInstruction(programID, source, mint, destination, c.publicKey, baseUnits, info.Decimals),
+	}
+
+	return c.sendInstructions(instructions, computeUnitsTokenTransfer, onSigned)
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestCalculateFee(t *testing.T) {
+	tests := []struct {
+		name   string
+		fee    transferFee
+		amount uint64
+		want   uint64
+	}{
+		{"no fee", transferFee{BasisPoints: 0, MaximumFee: 100}, 1000, 0},
+		{"zero amount", transferFee{BasisPoints: 100, MaximumFee: 100}, 0, 0},
+		{"one percent", transferFee{BasisPoints: 100, MaximumFee: 1000}, 10000, 100},
+		{"rounds up", transferFee{BasisPoints: 100, MaximumFee: 1000}, 150, 2},
+		{"capped", transferFee{BasisPoints: 500, MaximumFee: 30}, 10000, 30},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.fee.calculateFee(tt.amount); got != tt.want {
+				t.Fatalf("calculateFee(%d) = %d, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGrossAmount(t *testing.T) {
+	tests := []struct {
+		name string
+		fee  transferFee
+		net  uint64
+		want uint64
+	}{
+		{"no fee", transferFee{BasisPoints: 0, MaximumFee: 100}, 1000, 1000},
+		{"one percent", transferFee{BasisPoints: 100, MaximumFee: 1_000_000}, 9900, 10000},
+		{"rounding", transferFee{BasisPoints: 100, MaximumFee: 1_000_000}, 150, 152},
+		{"capped", transferFee{BasisPoints: 500, MaximumFee: 30}, 10000, 10030},
+		{"full percentage", transferFee{BasisPoints: 10000, MaximumFee: 50}, 1000, 1050},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gross := tt.fee.grossAmount(tt.net)
+			if gross != tt.want {
+				t.Fatalf("grossAmount(%d) = %d, want %d", tt.net, gross, tt.want)
+			}
+			// The recipient gets at least net, and one less would fall short
+			if got := gross - tt.fee.calculateFee(gross); got < tt.net {
+				t.Fatalf("net of %d is %d, below %d", gross, got, tt.net)
+			}
+			if gross > tt.net {
+				if less := gross - 1; less-tt.fee.calculateFee(less) >= tt.net {
+					t.Fatalf("grossAmount(%d) = %d isn't the smallest, %d also works", tt.net, gross, less)
+				}
+			}
+		})
+	}
+}
+
+func TestActiveFee(t *testing.T) {
+	older := &transferFee{Epoch: 0, BasisPoints: 50}
+	newer := &transferFee{Epoch: 100, BasisPoints: 75}
+	info := &mintInfo{OlderTransferFee: older, NewerTransferFee: newer}
+
+	if got := info.activeFee(99); got != older {
+		t.Errorf("activeFee(99) = %+v, want the older fee", got)
+	}
+	if got := info.activeFee(100); got != newer {
+		t.Errorf("activeFee(100) = %+v, want the newer fee", got)
+	}
+	if got := (&mintInfo{}).activeFee(100); got != nil {
+		t.Errorf("activeFee without the extension = %+v, want nil", got)
+	}
+}
+
+func TestParseMintExtensions(t *testing.T) {
+	putFee := func(b []byte, epoch, maximum uint64, bps uint16) {
+		binary.LittleEndian.PutUint64(b[0:], epoch)
+		binary.LittleEndian.PutUint64(b[8:], maximum)
+		binary.LittleEndian.PutUint16(b[16:], bps)
+	}
+
+	// An unrelated extension, then the transfer fee config
+	data := make([]byte, token2022ExtensionsOffset)
+	data = binary.LittleEndian.AppendUint16(data, 9)
+	data = binary.LittleEndian.AppendUint16(data, 2)
+	data = append(data, 0, 0)
+	data = binary.LittleEndian.AppendUint16(data, extensionTransferFeeConfig)
+	data = binary.LittleEndian.AppendUint16(data, transferFeeConfigSize)
+	config := make([]byte, transferFeeConfigSize)
+	putFee(config[72:], 10, 500, 25)
+	putFee(config[90:], 20, 900, 40)
+	data = append(data, config...)
+
+	var info mintInfo
+	parseMintExtensions(data, &info)
+	if info.OlderTransferFee == nil || *info.OlderTransferFee != (transferFee{Epoch: 10, MaximumFee: 500, BasisPoints: 25}) {
+		t.Errorf("older fee = %+v", info.OlderTransferFee)
+	}
+	if info.NewerTransferFee == nil || *info.NewerTransferFee != (transferFee{Epoch: 20, MaximumFee: 900, BasisPoints: 40}) {
+		t.Errorf("newer fee = %+v", info.NewerTransferFee)
+	}
+
+	// A truncated extension is ignored rather than read past the end
+	var truncated mintInfo
+	parseMintExtensions(data[:len(data)-1], &truncated)
+	if truncated.NewerTransferFee != nil {
+		t.Errorf("truncated extension was parsed: %+v", truncated.NewerTransferFee)
+	}
+}
@@ -1,63 +1,66 @@
 package utils
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
+	"log"
 	"net/http"
-	"net/url"
-	"time"
 )
 
 // TurnstileClient is a client for interacting with the Cloudflare Turnstile API
 type TurnstileClient struct {
 	secretKey  string
+	verifyURL  string
+	hostnames  []string
+	action     string
 	httpClient *http.Client
 }
 
-// TurnstileResponse represents a response from the Turnstile API
-type TurnstileResponse struct {
-	Success     bool      `json:"success"`
-	ChallengeTS time.Time `json:"challenge_ts"`
-	Hostname    string    `json:"hostname"`
-	ErrorCodes  []string  `json:"error-codes"`
-}
-
-// NewTurnstileClient creates a new Turnstile client
-func NewTurnstileClient(secretKey string) *TurnstileClient {
+// NewTurnstileClient creates a new Turnstile client. An empty verifyURL uses
+// Cloudflare's siteverify endpoint; empty hostnames or action accept any.
+func NewTurnstileClient(secretKey, verifyURL string, hostnames []string, action string) *TurnstileClient {
 	return &TurnstileClient{
 		secretKey: secretKey,
+		verifyURL: orDefault(verifyURL, turnstileVerifyURL),
+		hostnames: hostnames,
+		action:    action,
 		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
+			Timeout: captchaVerifyTimeout,
 		},
 	}
 }
 
-// VerifyToken verifies a Turnstile token
-func (t *TurnstileClient) VerifyToken(token string) (bool, error) {
-	// If no secret key is set, bypass verification (for development/testing)
-	if t.secretKey == "" || t.secretKey == "your-turnstile-secret-key" {
-		return true, nil
+// Verify verifies a Turnstile token
+func (t *TurnstileClient) Verify(ctx context.Context, token, remoteIP string) (bool, error) {
+	// The idempotency key lets a failed call be retried; a token can
+	// otherwise only be verified once
+	form := siteVerifyForm(t.secretKey, token, remoteIP)
+	key, err := newIdempotencyKey()
+	if err != nil {
+		return false, err
 	}
+	form.Set("idempotency_key", key)
 
-	// Make request to Turnstile API
-	resp, err := http.PostForm("https://challenges.cloudflare.com/turnstile/v0/siteverify", url.Values{
-		"secret":   {t.secretKey},
-		"response": {token},
-	})
+	// Make request to Turnstile API, retrying once on transport errors
+	result, err := siteVerify(ctx, t.httpClient, t.verifyURL, form)
+	if err != nil && ctx.Err() == nil {
+		log.Printf("[Turnstile] Verification failed, retrying: %v", err)
+		result, err = siteVerify(ctx, t.httpClient, t.verifyURL, form)
+	}
 	if err != nil {
 		return false, err
 	}
-	defer resp.Body.Close()
 
-	// Parse response
-	var turnstileResp TurnstileResponse
-	if err := json.NewDecoder(resp.Body).Decode(&turnstileResp); err != nil {
-		return false, err
+	if !result.Success && len(result.ErrorCodes) > 0 {
+		return false, fmt.Errorf("turnstile verification failed: %v", result.ErrorCodes)
+	}
+	if !result.Success {
+		return false, nil
 	}
 
-	if !turnstileResp.Success && len(turnstileResp.ErrorCodes) > 0 {
-		return false, fmt.Errorf("turnstile verification failed: %v", turnstileResp.ErrorCodes)
+	if err := result.checkClaims("turnstile", t.hostnames, t.action); err != nil {
+		return false, err
 	}
 
-	return turnstileResp.Success, nil
+	return true, nil
 }
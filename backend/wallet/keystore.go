@@ -0,0 +1,141 @@
+package wallet
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gagliardetto/solana-go"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Keystore format and default KDF cost: argon2id with 64 MiB of memory,
+// which takes a fraction of a second to unlock at startup
+const (
+	keystoreVersion = 1
+	kdfArgon2id     = "argon2id"
+	cipherXChaCha   = "xchacha20-poly1305"
+
+	defaultArgonTime    = 3
+	defaultArgonMemory  = 64 * 1024 // in KiB
+	defaultArgonThreads = 4
+)
+
+// ErrWrongPassphrase is returned when a keystore can't be decrypted
+var ErrWrongPassphrase = errors.New("wrong passphrase or corrupted keystore")
+
+// keystore is an encrypted keypair file. The public key is stored in the
+// clear so operators can tell keystores apart, and is bound to the
+// ciphertext as associated data.
+type keystore struct {
+	Version    int       `json:"version"`
+	PublicKey  string    `json:"publicKey"`
+	KDF        kdfParams `json:"kdf"`
+	Cipher     string    `json:"cipher"`
+	Nonce      []byte    `json:"nonce"`
+	Ciphertext []byte    `json:"ciphertext"`
+}
+
+// kdfParams are the argon2id parameters the key was derived with
+type kdfParams struct {
+	Name    string `json:"name"`
+	Salt    []byte `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"` // in KiB
+	Threads uint8  `json:"threads"`
+}
+
+// IsKeystore reports whether a wallet file is an encrypted keystore rather
+// than a plain keypair
+func IsKeystore(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+// EncryptKeystore encrypts a keypair with a passphrase
+func EncryptKeystore(key solana.PrivateKey, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("passphrase must not be empty")
+	}
+	if _, err := checkKeypair(key); err != nil {
+		return nil, err
+	}
+
+	ks := keystore{
+		Version:   keystoreVersion,
+		PublicKey: key.PublicKey().String(),
+		KDF: kdfParams{
+			Name:    kdfArgon2id,
+			Salt:    make([]byte, 16),
+			Time:    defaultArgonTime,
+			Memory:  defaultArgonMemory,
+			Threads: defaultArgonThreads,
+		},
+		Cipher: cipherXChaCha,
+		Nonce:  make([]byte, chacha20poly1305.NonceSizeX),
+	}
+	if _, err := rand.Read(ks.KDF.Salt); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(ks.Nonce); err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(ks.KDF.derive(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	ks.Ciphertext = aead.Seal(nil, ks.Nonce, key, ks.associatedData())
+
+	return json.MarshalIndent(ks, "", "  ")
+}
+
+// DecryptKeystore decrypts a keystore with its passphrase
+func DecryptKeystore(data []byte, passphrase string) (solana.PrivateKey, error) {
+	var ks keystore
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, fmt.Errorf("failed to parse keystore: %w", err)
+	}
+	if ks.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version %d", ks.Version)
+	}
+	if ks.KDF.Name != kdfArgon2id || ks.Cipher != cipherXChaCha {
+		return nil, fmt.Errorf("unsupported keystore KDF %q or cipher %q", ks.KDF.Name, ks.Cipher)
+	}
+	if ks.KDF.Time == 0 || ks.KDF.Memory == 0 || ks.KDF.Threads == 0 || len(ks.KDF.Salt) == 0 {
+		return nil, errors.New("keystore has invalid KDF parameters")
+	}
+
+	aead, err := chacha20poly1305.NewX(ks.KDF.derive(passphrase))
+	if err != nil {
+		return nil, err
+	}
+	if len(ks.Nonce) != aead.NonceSize() {
+		return nil, errors.New("keystore has an invalid nonce")
+	}
+	plain, err := aead.Open(nil, ks.Nonce, ks.Ciphertext, ks.associatedData())
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+
+	key, err := checkKeypair(solana.PrivateKey(plain))
+	if err != nil {
+		return nil, err
+	}
+	if key.PublicKey().String() != ks.PublicKey {
+		return nil, errors.New("keystore's public key doesn't match its contents")
+	}
+	return key, nil
+}
+
+// derive stretches a passphrase into a 256-bit key
+func (p kdfParams) derive(passphrase string) []byte {
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, chacha20poly1305.KeySize)
+}
+
+// associatedData binds the format and public key to the ciphertext
+func (ks *keystore) associatedData() []byte {
+	return []byte(fmt.Sprintf("solana-faucet-keystore:%d:%s", ks.Version, ks.PublicKey))
+}
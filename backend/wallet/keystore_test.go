@@ -0,0 +1,111 @@
+package wallet
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+func TestKeystoreRoundTrip(t *testing.T) {
+	key := solana.NewWallet().PrivateKey
+	data, err := EncryptKeystore(key, "correct horse")
+	if err != nil {
+		t.Fatalf("EncryptKeystore: %v", err)
+	}
+	if !IsKeystore(data) {
+		t.Fatal("encrypted keystore isn't recognised as one")
+	}
+
+	// A keystore with its clear-text public key swapped for another one
+	var swapped map[string]interface{}
+	if err := json.Unmarshal(data, &swapped); err != nil {
+		t.Fatal(err)
+	}
+	swapped["publicKey"] = solana.NewWallet().PublicKey().String()
+	swappedData, err := json.Marshal(swapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase string
+		wantErr    error
+	}{
+		{name: "right passphrase", data: data, passphrase: "correct horse"},
+		{name: "wrong passphrase", data: data, passphrase: "battery staple", wantErr: ErrWrongPassphrase},
+		{name: "swapped public key", data: swappedData, passphrase: "correct horse", wantErr: ErrWrongPassphrase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecryptKeystore(tt.data, tt.passphrase)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("DecryptKeystore = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !got.PublicKey().Equals(key.PublicKey()) {
+				t.Fatalf("decrypted key %s, want %s", got.PublicKey(), key.PublicKey())
+			}
+		})
+	}
+}
+
+func TestEncryptKeystoreRequiresPassphrase(t *testing.T) {
+	if _, err := EncryptKeystore(solana.NewWallet().PrivateKey, ""); err == nil {
+		t.Fatal("keystore was encrypted with an empty passphrase")
+	}
+}
+
+func TestLoadKeystoreFile(t *testing.T) {
+	key := solana.NewWallet().PrivateKey
+	data, err := EncryptKeystore(key, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "wallet.keystore.json")
+	passphraseFile := filepath.Join(dir, "passphrase")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(passphraseFile, []byte("correct horse\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Check(Options{Path: path}); err == nil {
+		t.Error("Check accepted a keystore without a passphrase")
+	}
+	opts := Options{Path: path, PassphraseFile: passphraseFile}
+	if err := Check(opts); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	got, err := Load(opts)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !got.PublicKey().Equals(key.PublicKey()) {
+		t.Fatalf("loaded key %s, want %s", got.PublicKey(), key.PublicKey())
+	}
+}
+
+func TestKeypairJSONRoundTrip(t *testing.T) {
+	key := solana.NewWallet().PrivateKey
+	data, err := EncodeKeypairJSON(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsKeystore(data) {
+		t.Fatal("plain keypair is mistaken for a keystore")
+	}
+	got, err := ParseKeypairJSON(data)
+	if err != nil {
+		t.Fatalf("ParseKeypairJSON: %v", err)
+	}
+	if !got.PublicKey().Equals(key.PublicKey()) {
+		t.Fatalf("parsed key %s, want %s", got.PublicKey(), key.PublicKey())
+	}
+}
@@ -0,0 +1,100 @@
+package wallet
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// hardenedOffset marks a hardened derivation index. Ed25519 only supports
+// hardened derivation.
+const hardenedOffset = 0x80000000
+
+// FromMnemonic derives a keypair from a BIP39 mnemonic along a SLIP-0010
+// ed25519 derivation path, the same way browser and hardware wallets do
+func FromMnemonic(mnemonic, passphrase, path string) (solana.PrivateKey, error) {
+	if err := checkMnemonic(mnemonic); err != nil {
+		return nil, err
+	}
+	indexes, err := parseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	seed := bip39.NewSeed(normalizeMnemonic(mnemonic), passphrase)
+
+	// SLIP-0010 master key, then one hardened child per path segment
+	key, chainCode := slip10(hmacSHA512([]byte("ed25519 seed"), seed))
+	for _, index := range indexes {
+		data := make([]byte, 0, 37)
+		data = append(data, 0)
+		data = append(data, key...)
+		data = binary.BigEndian.AppendUint32(data, index)
+		key, chainCode = slip10(hmacSHA512(chainCode, data))
+	}
+
+	return solana.PrivateKey(ed25519FromSeed(key)), nil
+}
+
+// checkMnemonic checks the words and checksum of a mnemonic
+func checkMnemonic(mnemonic string) error {
+	if !bip39.IsMnemonicValid(normalizeMnemonic(mnemonic)) {
+		return errors.New("mnemonic is not a valid BIP39 English phrase")
+	}
+	return nil
+}
+
+// normalizeMnemonic collapses whitespace and case in a mnemonic
+func normalizeMnemonic(mnemonic string) string {
+	return strings.ToLower(strings.Join(strings.Fields(mnemonic), " "))
+}
+
+// parseDerivationPath parses a path such as m/44'/501'/0'/0'. Every
+// segment must be hardened.
+func parseDerivationPath(path string) ([]uint32, error) {
+	if path == "" {
+		path = DefaultDerivationPath
+	}
+	segments := strings.Split(path, "/")
+	if segments[0] != "m" {
+		return nil, fmt.Errorf("derivation path %q must start with m", path)
+	}
+
+	indexes := make([]uint32, 0, len(segments)-1)
+	for _, segment := range segments[1:] {
+		trimmed := strings.TrimRight(segment, "'h")
+		if trimmed == segment {
+			return nil, fmt.Errorf("derivation path %q: segment %q must be hardened, ed25519 has no normal derivation", path, segment)
+		}
+		index, err := strconv.ParseUint(trimmed, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("derivation path %q: invalid segment %q", path, segment)
+		}
+		indexes = append(indexes, uint32(index)+hardenedOffset)
+	}
+	return indexes, nil
+}
+
+// slip10 splits an HMAC-SHA512 output into a key and chain code
+func slip10(sum []byte) ([]byte, []byte) {
+	return sum[:32], sum[32:]
+}
+
+func hmacSHA512(key, data []byte) []byte {
+	m := hmac.New(sha512.New, key)
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+// ed25519FromSeed expands a 32-byte seed into a 64-byte keypair
+func ed25519FromSeed(seed []byte) []byte {
+	return ed25519.NewKeyFromSeed(seed)
+}
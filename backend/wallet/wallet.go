@@ -0,0 +1,166 @@
+// Package wallet loads the faucet's keypair from the supported sources: a
+// Solana CLI keypair file, an encrypted keystore file, a base58 secret key
+// or a BIP39 mnemonic.
+package wallet
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// DefaultDerivationPath is the path wallets such as Phantom and Solflare
+// derive their first account from
+const DefaultDerivationPath = "m/44'/501'/0'/0'"
+
+// Options says where to load the keypair from. SecretKey takes precedence
+// over Mnemonic, which takes precedence over Path.
+type Options struct {
+	Path               string // keypair JSON or encrypted keystore file
+	SecretKey          string // base58 64-byte keypair
+	Mnemonic           string // BIP39 mnemonic
+	MnemonicPassphrase string // optional BIP39 passphrase
+	DerivationPath     string // defaults to DefaultDerivationPath
+	Passphrase         string // unlocks an encrypted keystore
+	PassphraseFile     string // file holding the keystore passphrase
+}
+
+// Source describes which source Load will use
+func (o Options) Source() string {
+	switch {
+	case o.SecretKey != "":
+		return "secret key"
+	case o.Mnemonic != "":
+		return "mnemonic"
+	default:
+		return "file " + o.Path
+	}
+}
+
+// Load returns the keypair from the configured source
+func Load(opts Options) (solana.PrivateKey, error) {
+	switch {
+	case opts.SecretKey != "":
+		return ParseSecretKey(opts.SecretKey)
+	case opts.Mnemonic != "":
+		return FromMnemonic(opts.Mnemonic, opts.MnemonicPassphrase, opts.DerivationPath)
+	}
+
+	data, err := os.ReadFile(opts.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wallet file: %w", err)
+	}
+	if !IsKeystore(data) {
+		return ParseKeypairJSON(data)
+	}
+
+	passphrase, err := opts.passphrase()
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, errors.New("wallet file is an encrypted keystore but no passphrase is configured")
+	}
+	return DecryptKeystore(data, passphrase)
+}
+
+// Check validates the options without decrypting or deriving anything, so
+// configuration problems are reported before startup does the slow work
+func Check(opts Options) error {
+	if opts.SecretKey != "" && opts.Mnemonic != "" {
+		return errors.New("set either a secret key or a mnemonic, not both")
+	}
+	switch {
+	case opts.SecretKey != "":
+		_, err := ParseSecretKey(opts.SecretKey)
+		return err
+	case opts.Mnemonic != "":
+		if err := checkMnemonic(opts.Mnemonic); err != nil {
+			return err
+		}
+		_, err := parseDerivationPath(opts.DerivationPath)
+		return err
+	}
+
+	if opts.Path == "" {
+		return errors.New("no wallet file, secret key or mnemonic is configured")
+	}
+	data, err := os.ReadFile(opts.Path)
+	if err != nil {
+		return err
+	}
+	if !IsKeystore(data) {
+		_, err := ParseKeypairJSON(data)
+		return err
+	}
+	passphrase, err := opts.passphrase()
+	if err != nil {
+		return err
+	}
+	if passphrase == "" {
+		return fmt.Errorf("%s is an encrypted keystore but no passphrase is configured", opts.Path)
+	}
+	return nil
+}
+
+// passphrase returns the keystore passphrase, read from PassphraseFile when
+// that is set
+func (o Options) passphrase() (string, error) {
+	if o.PassphraseFile == "" {
+		return o.Passphrase, nil
+	}
+	data, err := os.ReadFile(o.PassphraseFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read wallet passphrase file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// ParseSecretKey parses a base58 64-byte keypair, as exported by most
+// wallets
+func ParseSecretKey(secret string) (solana.PrivateKey, error) {
+	key, err := solana.PrivateKeyFromBase58(strings.TrimSpace(secret))
+	if err != nil {
+		return nil, errors.New("secret key is not valid base58")
+	}
+	return checkKeypair(key)
+}
+
+// ParseKeypairJSON parses a Solana CLI keypair file: a JSON array of the
+// 64 keypair bytes
+func ParseKeypairJSON(data []byte) (solana.PrivateKey, error) {
+	var keyBytes []byte
+	if err := json.Unmarshal(bytes.TrimSpace(data), &keyBytes); err != nil {
+		return nil, fmt.Errorf("failed to parse wallet: %w", err)
+	}
+	return checkKeypair(solana.PrivateKey(keyBytes))
+}
+
+// EncodeKeypairJSON renders a keypair in the Solana CLI file format
+func EncodeKeypairJSON(key solana.PrivateKey) ([]byte, error) {
+	ints := make([]int, len(key))
+	for i, b := range key {
+		ints[i] = int(b)
+	}
+	return json.Marshal(ints)
+}
+
+// checkKeypair checks a 64-byte keypair's public half matches its seed
+func checkKeypair(key solana.PrivateKey) (solana.PrivateKey, error) {
+	if len(key) != 64 {
+		return nil, fmt.Errorf("keypair must be 64 bytes, got %d", len(key))
+	}
+	if _, err := key.Sign([]byte{}); err != nil {
+		return nil, err
+	}
+	derived := solana.PrivateKey(ed25519FromSeed(key[:32]))
+	if !bytes.Equal(derived[32:], key[32:]) {
+		return nil, errors.New("keypair's public key doesn't match its secret")
+	}
+	return key, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/maestroi/solana-faucet/backend/config"
+	"github.com/maestroi/solana-faucet/backend/wallet"
+	"golang.org/x/term"
+)
+
+// walletUsage describes the wallet subcommand
+const walletUsage = `Usage:
+  faucet wallet convert -in wallet.json -out wallet.keystore.json
+  faucet wallet address`
+
+// runWalletCommand manages the faucet wallet from the command line and
+// returns the process exit code
+func runWalletCommand(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, walletUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "convert":
+		return convertWallet(cfg, args[1:])
+	case "address":
+		return printWalletAddress(cfg)
+	default:
+		fmt.Fprintln(os.Stderr, walletUsage)
+		return 2
+	}
+}
+
+// convertWallet encrypts a keypair file into a keystore. The passphrase
+// comes from FAUCET_WALLET_PASSPHRASE(_FILE) when set and is prompted for
+// otherwise.
+func convertWallet(cfg *config.Config, args []string) int {
+	fs := flag.NewFlagSet("wallet convert", flag.ContinueOnError)
+	in := fs.String("in", cfg.Solana.FaucetWalletPath, "Keypair JSON file to encrypt")
+	out := fs.String("out", "", "Keystore file to write")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *out == "" {
+		fmt.Fprintln(os.Stderr, "wallet convert: -out is required")
+		return 2
+	}
+
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read wallet: %v\n", err)
+		return 1
+	}
+	if wallet.IsKeystore(data) {
+		fmt.Fprintf(os.Stderr, "%s is already an encrypted keystore\n", *in)
+		return 1
+	}
+	key, err := wallet.ParseKeypairJSON(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to parse %s: %v\n", *in, err)
+		return 1
+	}
+
+	passphrase, err := newPassphrase(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read passphrase: %v\n", err)
+		return 1
+	}
+
+	encrypted, err := wallet.EncryptKeystore(key, passphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encrypt wallet: %v\n", err)
+		return 1
+	}
+
+	// Never overwrite an existing file, it may be the only copy of a key
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create keystore: %v\n", err)
+		return 1
+	}
+	if _, err := f.Write(append(encrypted, '\n')); err != nil {
+		f.Close()
+		os.Remove(*out)
+		fmt.Fprintf(os.Stderr, "Failed to write keystore: %v\n", err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(*out)
+		fmt.Fprintf(os.Stderr, "Failed to write keystore: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("Encrypted wallet %s into %s\n\nPoint FAUCET_WALLET_PATH at it, set FAUCET_WALLET_PASSPHRASE or\nFAUCET_WALLET_PASSPHRASE_FILE, then delete %s once you have a backup.\n",
+		key.PublicKey(), *out, *in)
+	return 0
+}
+
+// printWalletAddress prints the public key of the configured wallet
+func printWalletAddress(cfg *config.Config) int {
+	opts := cfg.WalletOptions()
+	key, err := wallet.Load(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load wallet from %s: %v\n", opts.Source(), err)
+		return 1
+	}
+	fmt.Println(key.PublicKey())
+	return 0
+}
+
+// newPassphrase returns the configured keystore passphrase, or prompts for
+// one twice on the terminal
+func newPassphrase(cfg *config.Config) (string, error) {
+	if cfg.Solana.WalletPassphraseFile != "" {
+		data, err := os.ReadFile(cfg.Solana.WalletPassphraseFile)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+	if cfg.Solana.WalletPassphrase != "" {
+		return cfg.Solana.WalletPassphrase, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("no terminal to prompt on, set FAUCET_WALLET_PASSPHRASE or FAUCET_WALLET_PASSPHRASE_FILE")
+	}
+	fmt.Fprint(os.Stderr, "New passphrase: ")
+	first, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Repeat passphrase: ")
+	second, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if string(first) != string(second) {
+		return "", errors.New("passphrases don't match")
+	}
+	if len(first) == 0 {
+		return "", errors.New("passphrase must not be empty")
+	}
+	return string(first), nil
+}